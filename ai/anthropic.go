@@ -0,0 +1,234 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"codesage/config"
+)
+
+const (
+	anthropicURL     = "https://api.anthropic.com/v1/messages"
+	anthropicVersion = "2023-06-01"
+)
+
+// AnthropicMessage is a single turn in a Messages API conversation.
+type AnthropicMessage struct {
+	Role    string           `json:"role"`
+	Content []AnthropicBlock `json:"content"`
+}
+
+// AnthropicBlock is one content block: text, a tool_use request from the
+// model or the tool_result answering it.
+type AnthropicBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+}
+
+// AnthropicTool declares a tool the model may use.
+type AnthropicTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"input_schema"`
+}
+
+// AnthropicToolChoice controls whether the model may use tools.
+type AnthropicToolChoice struct {
+	Type string `json:"type"`
+}
+
+// AnthropicRequest is the Messages API request payload.
+type AnthropicRequest struct {
+	Model         string               `json:"model"`
+	System        string               `json:"system,omitempty"`
+	MaxTokens     int                  `json:"max_tokens"`
+	Temperature   *float64             `json:"temperature,omitempty"`
+	TopP          *float64             `json:"top_p,omitempty"`
+	StopSequences []string             `json:"stop_sequences,omitempty"`
+	Messages      []AnthropicMessage   `json:"messages"`
+	Tools         []AnthropicTool      `json:"tools,omitempty"`
+	ToolChoice    *AnthropicToolChoice `json:"tool_choice,omitempty"`
+}
+
+// AnthropicResponse is the Messages API response payload.
+type AnthropicResponse struct {
+	Model      string           `json:"model"`
+	Content    []AnthropicBlock `json:"content"`
+	StopReason string           `json:"stop_reason"`
+	Usage      struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// AnthropicReviewer reviews diffs with the Anthropic Messages API.
+type AnthropicReviewer struct {
+	APIKey string
+	Params config.ModelParams
+	client *apiClient
+}
+
+func init() {
+	Register("anthropic", NewAnthropicReviewer)
+}
+
+// NewAnthropicReviewer builds an Anthropic provider from the configured API key.
+func NewAnthropicReviewer(cfg *config.Config) (Reviewer, error) {
+	if cfg.AnthropicKey == "" {
+		return nil, fmt.Errorf("Anthropic API key missing")
+	}
+	params := cfg.Models["anthropic"]
+	if params.Model == "" {
+		return nil, fmt.Errorf("Anthropic model missing")
+	}
+	// max_tokens is mandatory in the Messages API.
+	if params.MaxTokens <= 0 {
+		params.MaxTokens = 2048
+	}
+	return &AnthropicReviewer{APIKey: cfg.AnthropicKey, Params: params, client: clientFor("anthropic", cfg)}, nil
+}
+
+func (a *AnthropicReviewer) Name() string { return "anthropic" }
+
+// Review sends diff + title to the Messages API. When the request offers
+// tools, the model may use them before answering.
+func (a *AnthropicReviewer) Review(ctx context.Context, req ReviewRequest) (*Review, error) {
+	reqBody := AnthropicRequest{
+		Model:         a.Params.Model,
+		System:        systemPrompt(req, a.Params),
+		MaxTokens:     a.Params.MaxTokens,
+		Temperature:   a.Params.Temperature,
+		TopP:          a.Params.TopP,
+		StopSequences: a.Params.StopSequences,
+		Messages: []AnthropicMessage{
+			{Role: "user", Content: []AnthropicBlock{{Type: "text", Text: reviewPrompt(req)}}},
+		},
+	}
+	session := newToolSession(req)
+	if session != nil {
+		reqBody.Tools = anthropicTools(session)
+	}
+
+	var out AnthropicResponse
+	var usage Usage
+	for {
+		var err error
+		if out, err = a.send(ctx, reqBody); err != nil {
+			return nil, err
+		}
+		usage.Add(Usage{PromptTokens: out.Usage.InputTokens, CompletionTokens: out.Usage.OutputTokens})
+		if session == nil || out.StopReason != "tool_use" {
+			break
+		}
+		if session.exhausted() {
+			return nil, fmt.Errorf("Anthropic kept using tools after the budget of %d steps was spent", session.maxSteps)
+		}
+		reqBody.Messages = append(reqBody.Messages, AnthropicMessage{Role: "assistant", Content: out.Content})
+		results := AnthropicMessage{Role: "user"}
+		for _, block := range out.Content {
+			if block.Type != "tool_use" {
+				continue
+			}
+			result := session.run(ctx, ToolCall{ID: block.ID, Name: block.Name, Args: block.Input})
+			results.Content = append(results.Content, AnthropicBlock{Type: "tool_result", ToolUseID: block.ID, Content: result})
+		}
+		if session.exhausted() {
+			results.Content = append(results.Content, AnthropicBlock{Type: "text", Text: finalNudge})
+			reqBody.ToolChoice = &AnthropicToolChoice{Type: "none"}
+		}
+		reqBody.Messages = append(reqBody.Messages, results)
+	}
+
+	var text strings.Builder
+	for _, block := range out.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+
+	review := &Review{
+		Text:     strings.TrimSpace(text.String()),
+		Provider: a.Name(),
+		Model:    a.Params.Model,
+		Usage:    usage,
+	}
+	switch out.StopReason {
+	case "end_turn", "stop_sequence":
+	case "max_tokens":
+		review.Truncated = true
+		review.StopReason = StopMaxTokens
+	case "refusal":
+		return nil, &BlockedError{Provider: "Anthropic", Stage: "response", Reason: "refusal"}
+	}
+	if review.Text == "" {
+		return nil, &EmptyResponseError{Provider: "Anthropic", FinishReason: out.StopReason, StopReason: review.StopReason}
+	}
+	return review, nil
+}
+
+// send makes one Messages API call.
+func (a *AnthropicReviewer) send(ctx context.Context, reqBody AnthropicRequest) (AnthropicResponse, error) {
+	var out AnthropicResponse
+	jsonData, err := json.Marshal(reqBody)
+	if err != nil {
+		return out, fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", anthropicURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return out, fmt.Errorf("failed to build request: %v", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("x-api-key", a.APIKey)
+	httpReq.Header.Set("anthropic-version", anthropicVersion)
+
+	resp, err := a.client.Do(httpReq)
+	if err != nil {
+		return out, fmt.Errorf("failed to call Anthropic API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return out, fmt.Errorf("failed to read response: %v", err)
+	}
+
+	if err := json.Unmarshal(body, &out); err != nil && resp.StatusCode == http.StatusOK {
+		return out, fmt.Errorf("failed to decode response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &APIError{Provider: "Anthropic", StatusCode: resp.StatusCode}
+		if out.Error != nil {
+			apiErr.Message = out.Error.Type + ": " + out.Error.Message
+		}
+		return out, apiErr
+	}
+	return out, nil
+}
+
+// anthropicTools declares the session's tools.
+func anthropicTools(s *toolSession) []AnthropicTool {
+	tools := make([]AnthropicTool, len(s.order))
+	for i, t := range s.order {
+		schema := t.Parameters
+		if schema == nil {
+			schema = map[string]any{"type": "object"}
+		}
+		tools[i] = AnthropicTool{Name: t.Name, Description: t.Description, InputSchema: schema}
+	}
+	return tools
+}
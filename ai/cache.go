@@ -0,0 +1,245 @@
+package ai
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"codesage/config"
+	"codesage/utils"
+)
+
+// CacheStore persists reviews by key. Implementations must be safe for
+// concurrent use.
+type CacheStore interface {
+	Get(key string) (*Review, bool)
+	Set(key string, review *Review)
+}
+
+type cacheEntry struct {
+	Key       string    `json:"key"`
+	Review    *Review   `json:"review"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// MemoryCache is an LRU cache with a TTL. When Backend is set, misses fall
+// through to it and writes go to both, so a disk cache survives restarts
+// while hot entries are served from memory.
+type MemoryCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	max     int
+	order   *list.List
+	entries map[string]*list.Element
+	Backend CacheStore
+}
+
+// NewMemoryCache builds an in-memory cache holding at most max entries.
+func NewMemoryCache(ttl time.Duration, max int, backend CacheStore) *MemoryCache {
+	return &MemoryCache{ttl: ttl, max: max, order: list.New(), entries: map[string]*list.Element{}, Backend: backend}
+}
+
+func (m *MemoryCache) Get(key string) (*Review, bool) {
+	m.mu.Lock()
+	if el, ok := m.entries[key]; ok {
+		entry := el.Value.(*cacheEntry)
+		if time.Now().Before(entry.ExpiresAt) {
+			m.order.MoveToFront(el)
+			m.mu.Unlock()
+			return entry.Review, true
+		}
+		m.order.Remove(el)
+		delete(m.entries, key)
+	}
+	m.mu.Unlock()
+
+	if m.Backend == nil {
+		return nil, false
+	}
+	review, ok := m.Backend.Get(key)
+	if ok {
+		m.put(key, review)
+	}
+	return review, ok
+}
+
+func (m *MemoryCache) Set(key string, review *Review) {
+	m.put(key, review)
+	if m.Backend != nil {
+		m.Backend.Set(key, review)
+	}
+}
+
+func (m *MemoryCache) put(key string, review *Review) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry := &cacheEntry{Key: key, Review: review, ExpiresAt: time.Now().Add(m.ttl)}
+	if el, ok := m.entries[key]; ok {
+		el.Value = entry
+		m.order.MoveToFront(el)
+		return
+	}
+	m.entries[key] = m.order.PushFront(entry)
+	for m.max > 0 && m.order.Len() > m.max {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.entries, oldest.Value.(*cacheEntry).Key)
+	}
+}
+
+// DiskCache stores one JSON file per key under Dir.
+type DiskCache struct {
+	Dir string
+	TTL time.Duration
+}
+
+func (d *DiskCache) path(key string) string {
+	return filepath.Join(d.Dir, key+".json")
+}
+
+func (d *DiskCache) Get(key string) (*Review, bool) {
+	data, err := os.ReadFile(d.path(key))
+	if err != nil {
+		return nil, false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Review == nil {
+		return nil, false
+	}
+	if time.Now().After(entry.ExpiresAt) {
+		os.Remove(d.path(key))
+		return nil, false
+	}
+	return entry.Review, true
+}
+
+func (d *DiskCache) Set(key string, review *Review) {
+	if err := os.MkdirAll(d.Dir, 0o700); err != nil {
+		utils.Errorf("review cache: %v", err)
+		return
+	}
+	data, err := json.Marshal(cacheEntry{Key: key, Review: review, ExpiresAt: time.Now().Add(d.TTL)})
+	if err != nil {
+		utils.Errorf("review cache: %v", err)
+		return
+	}
+	// Write then rename so a concurrent reader never sees a partial file.
+	tmp, err := os.CreateTemp(d.Dir, "tmp-*")
+	if err != nil {
+		utils.Errorf("review cache: %v", err)
+		return
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), d.path(key))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		utils.Errorf("review cache: %v", err)
+	}
+}
+
+var (
+	cacheOnce  sync.Once
+	cacheStore CacheStore
+)
+
+// cacheFor returns the process-wide cache configured by cfg, or nil when
+// caching is off.
+func cacheFor(cfg *config.Config) CacheStore {
+	cacheOnce.Do(func() {
+		switch strings.ToLower(cfg.AICache) {
+		case "off", "none", "false":
+			return
+		case "disk":
+			disk := &DiskCache{Dir: cfg.AICacheDir, TTL: cfg.AICacheTTL}
+			cacheStore = NewMemoryCache(cfg.AICacheTTL, cfg.AICacheMaxEntries, disk)
+		default:
+			cacheStore = NewMemoryCache(cfg.AICacheTTL, cfg.AICacheMaxEntries, nil)
+		}
+	})
+	return cacheStore
+}
+
+// normalizeDiff removes differences that do not change the patch content:
+// line endings and trailing whitespace.
+func normalizeDiff(diff string) string {
+	lines := strings.Split(strings.ReplaceAll(diff, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t\r")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// Cached reuses earlier reviews of identical content. The key combines the
+// configured providers and models, the prompt templates' version and the
+// normalized diff, so changing any of them produces a fresh review.
+type Cached struct {
+	Reviewer
+	Store CacheStore
+	// Identity describes the provider/model configuration, e.g.
+	// "gemini@gemini-2.5-pro,openai@gpt-4o-mini".
+	Identity string
+}
+
+func (c *Cached) key(req ReviewRequest) string {
+	diff := req.Diff
+	if diff == "" {
+		diff = RenderDiff(req.Files)
+	}
+	// Reviews that could use tools differ from diff-only ones.
+	var tools []string
+	for _, t := range req.Tools {
+		tools = append(tools, t.Name)
+	}
+	h := sha256.New()
+	for _, part := range []string{c.Identity, promptsOf(req).Version(), strings.Join(tools, ","), normalizeDiff(diff)} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Review serves a cached review when one exists, unless req.Fresh is set.
+func (c *Cached) Review(ctx context.Context, req ReviewRequest) (*Review, error) {
+	key := c.key(req)
+	if !req.Fresh {
+		if cached, ok := c.Store.Get(key); ok {
+			utils.Infof("review cache hit %s", key[:12])
+			hit := *cached
+			hit.Cached = true
+			hit.Usage = Usage{}
+			return &hit, nil
+		}
+	}
+	review, err := c.Reviewer.Review(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if complete(review) {
+		c.Store.Set(key, review)
+	} else {
+		utils.Infof("not caching incomplete review %s", key[:12])
+	}
+	return review, nil
+}
+
+// complete reports whether a review is worth reusing. Truncated, partial
+// and unparsed reviews usually come from transient provider trouble, and a
+// re-delivery should get another try.
+func complete(review *Review) bool {
+	if review.Truncated || review.Partial {
+		return false
+	}
+	return review.Summary != "" || len(review.Findings) > 0
+}
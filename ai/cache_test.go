@@ -0,0 +1,75 @@
+package ai
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCachedSkipsIncompleteReviews(t *testing.T) {
+	tests := []struct {
+		name   string
+		review Review
+		cached bool
+	}{
+		{"complete", Review{Summary: "fine"}, true},
+		{"findings only", Review{Findings: []Finding{{File: "a.go", Message: "m"}}}, true},
+		{"unparsed", Review{Text: "prose the parser rejected"}, false},
+		{"truncated", Review{Summary: "half", Truncated: true, StopReason: StopMaxTokens}, false},
+		{"partial", Review{Summary: "some parts failed", Partial: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			inner := &stubReviewer{review: func(context.Context, ReviewRequest) (*Review, error) {
+				calls++
+				review := tt.review
+				return &review, nil
+			}}
+			c := &Cached{Reviewer: inner, Store: NewMemoryCache(time.Hour, 10, nil), Identity: "stub"}
+			req := ReviewRequest{Diff: "+x"}
+			for i := 0; i < 2; i++ {
+				if _, err := c.Review(context.Background(), req); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want := 2
+			if tt.cached {
+				want = 1
+			}
+			if calls != want {
+				t.Errorf("provider called %d times, want %d", calls, want)
+			}
+		})
+	}
+}
+
+func TestCachedKeyIgnoresWhitespace(t *testing.T) {
+	calls := 0
+	inner := &stubReviewer{review: func(context.Context, ReviewRequest) (*Review, error) {
+		calls++
+		return &Review{Summary: "fine", Usage: Usage{PromptTokens: 10}}, nil
+	}}
+	c := &Cached{Reviewer: inner, Store: NewMemoryCache(time.Hour, 10, nil), Identity: "stub"}
+	ctx := context.Background()
+	c.Review(ctx, ReviewRequest{Diff: "+x\n"})
+	hit, _ := c.Review(ctx, ReviewRequest{Diff: "+x  \r\n"})
+	if calls != 1 || !hit.Cached || hit.Usage.PromptTokens != 0 {
+		t.Errorf("calls = %d, cached = %v, usage = %+v; want one call and a free cache hit", calls, hit.Cached, hit.Usage)
+	}
+	c.Review(ctx, ReviewRequest{Diff: "+x", Fresh: true})
+	if calls != 2 {
+		t.Errorf("Fresh did not bypass the cache")
+	}
+}
+
+// stubReviewer is a Reviewer backed by a function.
+type stubReviewer struct {
+	review func(ctx context.Context, req ReviewRequest) (*Review, error)
+}
+
+func (s *stubReviewer) Name() string { return "stub" }
+
+func (s *stubReviewer) Review(ctx context.Context, req ReviewRequest) (*Review, error) {
+	return s.review(ctx, req)
+}
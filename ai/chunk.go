@@ -0,0 +1,427 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"codesage/config"
+	"codesage/utils"
+)
+
+// FileDiff is the patch for a single changed file.
+type FileDiff struct {
+	Path  string
+	Patch string
+	// Context is code around the patch's hunks at the PR head, with line
+	// numbers, when it was fetched; see package enrich.
+	Context string
+}
+
+// RenderDiff joins file patches into the combined diff sent to providers.
+func RenderDiff(files []FileDiff) string {
+	var b strings.Builder
+	for _, f := range files {
+		if f.Patch == "" {
+			continue
+		}
+		fmt.Fprintf(&b, "\n--- %s ---\n%s\n", f.Path, f.Patch)
+		if f.Context != "" {
+			fmt.Fprintf(&b, "\n--- %s: surrounding code after the change, for reference only ---\n%s\n", f.Path, f.Context)
+		}
+	}
+	return b.String()
+}
+
+// EstimateTokens approximates the token count of s. Code averages a little
+// under four bytes per token across the tokenizers we target.
+func EstimateTokens(s string) int {
+	return (len(s) + 3) / 4
+}
+
+// ChunkDiff splits files into groups whose rendered diff stays within
+// budget tokens. Whole files are kept together where possible; larger files
+// are split between hunks, and a hunk that alone exceeds the budget is split
+// between lines with a fresh hunk header so the model keeps line numbers.
+// Split files lose their Context.
+func ChunkDiff(files []FileDiff, budget int) [][]FileDiff {
+	var chunks [][]FileDiff
+	var current []FileDiff
+	used := 0
+	flush := func() {
+		if len(current) > 0 {
+			chunks = append(chunks, current)
+			current, used = nil, 0
+		}
+	}
+	add := func(f FileDiff) {
+		cost := EstimateTokens(RenderDiff([]FileDiff{f}))
+		if used+cost > budget {
+			flush()
+		}
+		current = append(current, f)
+		used += cost
+	}
+
+	for _, f := range files {
+		if f.Patch == "" {
+			continue
+		}
+		if EstimateTokens(RenderDiff([]FileDiff{f})) <= budget {
+			add(f)
+			continue
+		}
+		for _, piece := range splitPatch(f, budget) {
+			add(piece)
+		}
+	}
+	flush()
+	return chunks
+}
+
+// splitPatch breaks one oversized file patch into pieces that fit budget.
+func splitPatch(f FileDiff, budget int) []FileDiff {
+	overhead := EstimateTokens(RenderDiff([]FileDiff{{Path: f.Path, Patch: " "}}))
+	limit := budget - overhead
+	if limit < 1 {
+		limit = 1
+	}
+
+	var pieces []FileDiff
+	var buf strings.Builder
+	emit := func() {
+		if buf.Len() > 0 {
+			pieces = append(pieces, FileDiff{Path: f.Path, Patch: strings.TrimSuffix(buf.String(), "\n")})
+			buf.Reset()
+		}
+	}
+	for _, hunk := range splitHunks(f.Patch) {
+		if EstimateTokens(buf.String()+hunk) <= limit {
+			buf.WriteString(hunk)
+			continue
+		}
+		emit()
+		if EstimateTokens(hunk) <= limit {
+			buf.WriteString(hunk)
+			continue
+		}
+		// A single hunk larger than the budget: split it between lines.
+		lines := strings.SplitAfter(hunk, "\n")
+		if lines[len(lines)-1] == "" {
+			lines = lines[:len(lines)-1]
+		}
+		oldLine, newLine, hasHeader := parseHunkHeader(lines[0])
+		// body counts the diff lines in buf after its header; a piece is
+		// only emitted once it has some, even if one line exceeds limit.
+		body := 0
+		for i, line := range lines {
+			if i == 0 && hasHeader {
+				buf.WriteString(line)
+				continue
+			}
+			if body > 0 && EstimateTokens(buf.String()+line) > limit {
+				emit()
+				body = 0
+				if hasHeader {
+					fmt.Fprintf(&buf, "@@ -%d +%d @@ (continued)\n", oldLine, newLine)
+				}
+			}
+			buf.WriteString(line)
+			body++
+			switch {
+			case strings.HasPrefix(line, "+"):
+				newLine++
+			case strings.HasPrefix(line, "-"):
+				oldLine++
+			case strings.HasPrefix(line, " "):
+				oldLine++
+				newLine++
+			}
+		}
+		emit()
+	}
+	emit()
+	return pieces
+}
+
+// parseHunkHeader reads the starting old and new line numbers from a
+// "@@ -a,b +c,d @@" header.
+func parseHunkHeader(line string) (oldStart, newStart int, ok bool) {
+	if !strings.HasPrefix(line, "@@") {
+		return 0, 0, false
+	}
+	var oldCount, newCount int
+	if _, err := fmt.Sscanf(line, "@@ -%d,%d +%d,%d @@", &oldStart, &oldCount, &newStart, &newCount); err == nil {
+		return oldStart, newStart, true
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return 0, 0, false
+	}
+	_, err1 := fmt.Sscanf(strings.Split(fields[1], ",")[0], "-%d", &oldStart)
+	_, err2 := fmt.Sscanf(strings.Split(fields[2], ",")[0], "+%d", &newStart)
+	return oldStart, newStart, err1 == nil && err2 == nil
+}
+
+// DiffLine is one line of a unified diff patch.
+type DiffLine struct {
+	// Kind is '+' for added, '-' for removed and ' ' for context lines.
+	Kind byte
+	// OldLine and NewLine are the line numbers in the old and new file; the
+	// side a line does not exist on is 0.
+	OldLine, NewLine int
+	Text             string
+}
+
+// ParsePatch splits a patch into lines with their file line numbers.
+// Hunk headers and "\ No newline at end of file" markers are skipped.
+func ParsePatch(patch string) []DiffLine {
+	var lines []DiffLine
+	oldLine, newLine := 0, 0
+	for _, raw := range strings.Split(patch, "\n") {
+		if o, n, ok := parseHunkHeader(raw); ok {
+			oldLine, newLine = o, n
+			continue
+		}
+		if raw == "" || newLine == 0 && oldLine == 0 {
+			continue
+		}
+		switch raw[0] {
+		case '+':
+			lines = append(lines, DiffLine{Kind: '+', NewLine: newLine, Text: raw[1:]})
+			newLine++
+		case '-':
+			lines = append(lines, DiffLine{Kind: '-', OldLine: oldLine, Text: raw[1:]})
+			oldLine++
+		case ' ':
+			lines = append(lines, DiffLine{Kind: ' ', OldLine: oldLine, NewLine: newLine, Text: raw[1:]})
+			oldLine++
+			newLine++
+		}
+	}
+	return lines
+}
+
+// splitHunks splits a patch before every "@@" hunk header. Each returned
+// hunk ends with a newline.
+func splitHunks(patch string) []string {
+	var hunks []string
+	var cur strings.Builder
+	for _, line := range strings.SplitAfter(patch, "\n") {
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "@@") && cur.Len() > 0 {
+			hunks = append(hunks, cur.String())
+			cur.Reset()
+		}
+		cur.WriteString(line)
+		if !strings.HasSuffix(line, "\n") {
+			cur.WriteString("\n")
+		}
+	}
+	if cur.Len() > 0 {
+		hunks = append(hunks, cur.String())
+	}
+	return hunks
+}
+
+// Chunked reviews large diffs in parts and merges the results. Each chunk
+// is reviewed in parallel by the wrapped reviewer, then a synthesis pass
+// turns the partial reports into one coherent review.
+type Chunked struct {
+	Reviewer
+	Budget      int
+	Concurrency int
+	MaxChunks   int
+}
+
+// NewChunked wraps r using the token budget configured for its providers.
+// A fallback chain gets the smallest budget of its members so that every
+// chunk fits whichever provider ends up answering.
+func NewChunked(r Reviewer, providers []string, cfg *config.Config) *Chunked {
+	budget := 0
+	for _, name := range providers {
+		b, ok := cfg.AITokenBudgets[strings.ToLower(name)]
+		if !ok {
+			b = cfg.AITokenBudget
+		}
+		if budget == 0 || (b > 0 && b < budget) {
+			budget = b
+		}
+	}
+	if budget <= 0 {
+		budget = 6000
+	}
+	return &Chunked{Reviewer: r, Budget: budget, Concurrency: cfg.AIChunkConcurrency, MaxChunks: cfg.AIMaxChunks}
+}
+
+type chunkResult struct {
+	review *Review
+	err    error
+}
+
+// Review splits req into chunks, reviews them and synthesises the results.
+func (c *Chunked) Review(ctx context.Context, req ReviewRequest) (*Review, error) {
+	files := req.Files
+	if len(files) == 0 {
+		files = []FileDiff{{Patch: req.Diff}}
+	}
+	chunks := ChunkDiff(files, c.Budget)
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("nothing to review")
+	}
+	var skipped []string
+	if c.MaxChunks > 0 && len(chunks) > c.MaxChunks {
+		for _, chunk := range chunks[c.MaxChunks:] {
+			for _, f := range chunk {
+				skipped = append(skipped, f.Path)
+			}
+		}
+		chunks = chunks[:c.MaxChunks]
+	}
+	if len(chunks) == 1 && len(skipped) == 0 {
+		req.Files = chunks[0]
+		req.Diff = RenderDiff(chunks[0])
+		return c.Reviewer.Review(ctx, req)
+	}
+	utils.Infof("reviewing diff in %d chunks of up to %d tokens", len(chunks), c.Budget)
+
+	results := make([]chunkResult, len(chunks))
+	concurrency := c.Concurrency
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	var done atomic.Int32
+	report := func() {
+		if req.OnProgress != nil {
+			req.OnProgress(Progress{PartsDone: int(done.Load()), Parts: len(chunks)})
+		}
+	}
+	report()
+	for i, chunk := range chunks {
+		wg.Add(1)
+		go func(i int, chunk []FileDiff) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			part := req
+			part.Title = fmt.Sprintf("%s (part %d of %d)", req.Title, i+1, len(chunks))
+			part.Files = chunk
+			part.Diff = RenderDiff(chunk)
+			// Streams of parallel parts would interleave; report parts instead.
+			part.OnProgress = nil
+			review, err := c.Reviewer.Review(ctx, part)
+			results[i] = chunkResult{review: review, err: err}
+			done.Add(1)
+			report()
+		}(i, chunk)
+	}
+	wg.Wait()
+
+	var partials []Report
+	var usage Usage
+	var failed []string
+	var first *Review
+	truncated := false
+	for i, res := range results {
+		if res.err != nil {
+			utils.Errorf("chunk %d of %d failed: %v", i+1, len(chunks), res.err)
+			failed = append(failed, fmt.Sprintf("part %d", i+1))
+			continue
+		}
+		if first == nil {
+			first = res.review
+		}
+		usage.Add(res.review.Usage)
+		truncated = truncated || res.review.Truncated
+		summary := res.review.Summary
+		if summary == "" && len(res.review.Findings) == 0 {
+			// The model answered in prose; keep it rather than lose the part.
+			summary = res.review.Text
+		}
+		partials = append(partials, Report{Summary: summary, Findings: res.review.Findings})
+	}
+	if first == nil {
+		return nil, fmt.Errorf("all %d chunks failed: %w", len(chunks), results[0].err)
+	}
+
+	review := c.synthesize(ctx, req, partials)
+	if review == nil {
+		review = mergeReports(first, partials)
+	}
+	review.Usage.Add(usage)
+	review.Coverage = coverageNote(failed, skipped)
+	review.Partial = len(failed) > 0 || len(skipped) > 0 || truncated
+	return review, nil
+}
+
+// synthesize asks the model to merge partial reports. It returns nil when
+// the synthesis call fails so the caller can merge mechanically instead.
+func (c *Chunked) synthesize(ctx context.Context, req ReviewRequest, partials []Report) *Review {
+	if len(partials) == 1 {
+		return nil
+	}
+	synth := ReviewRequest{Title: req.Title, Partials: partials, Prompts: req.Prompts}
+	if req.OnProgress != nil {
+		synth.OnProgress = func(p Progress) {
+			p.PartsDone, p.Parts = len(partials), len(partials)
+			req.OnProgress(p)
+		}
+	}
+	review, err := c.Reviewer.Review(ctx, synth)
+	if err != nil {
+		utils.Errorf("synthesis pass failed, merging chunk results directly: %v", err)
+		return nil
+	}
+	if review.Summary == "" && len(review.Findings) == 0 {
+		utils.Errorf("synthesis pass returned no structured report, merging chunk results directly")
+		return nil
+	}
+	return review
+}
+
+// mergeReports combines partial reports without another model call.
+func mergeReports(base *Review, partials []Report) *Review {
+	merged := Report{}
+	seen := map[string]bool{}
+	var summaries []string
+	for _, p := range partials {
+		if p.Summary != "" {
+			summaries = append(summaries, p.Summary)
+		}
+		for _, f := range p.Findings {
+			key := fmt.Sprintf("%s:%d:%s", f.File, f.StartLine, f.Message)
+			if !seen[key] {
+				seen[key] = true
+				merged.Findings = append(merged.Findings, f)
+			}
+		}
+	}
+	merged.Summary = strings.Join(summaries, "\n\n")
+	return &Review{
+		Text:         RenderMarkdown(&merged),
+		Provider:     base.Provider,
+		Model:        base.Model,
+		Summary:      merged.Summary,
+		Findings:     merged.Findings,
+		FallbackFrom: base.FallbackFrom,
+	}
+}
+
+// coverageNote describes the failed and skipped parts of a review, or
+// returns "" when every part was reviewed.
+func coverageNote(failed, skipped []string) string {
+	var b strings.Builder
+	if len(failed) > 0 {
+		fmt.Fprintf(&b, "_Some parts of this PR could not be reviewed (%s)._\n", strings.Join(failed, ", "))
+	}
+	if len(skipped) > 0 {
+		fmt.Fprintf(&b, "_This PR is too large to review in full; %d file(s) were skipped._\n", len(skipped))
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
@@ -0,0 +1,138 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParsePatch(t *testing.T) {
+	patch := "@@ -10,3 +10,4 @@ func main() {\n a\n-b\n+c\n+d\n e\n\\ No newline at end of file\n@@ -40 +41 @@\n-x\n+y"
+	want := []DiffLine{
+		{Kind: ' ', OldLine: 10, NewLine: 10, Text: "a"},
+		{Kind: '-', OldLine: 11, Text: "b"},
+		{Kind: '+', NewLine: 11, Text: "c"},
+		{Kind: '+', NewLine: 12, Text: "d"},
+		{Kind: ' ', OldLine: 12, NewLine: 13, Text: "e"},
+		{Kind: '-', OldLine: 40, Text: "x"},
+		{Kind: '+', NewLine: 41, Text: "y"},
+	}
+	got := ParsePatch(patch)
+	if len(got) != len(want) {
+		t.Fatalf("ParsePatch returned %d lines, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("line %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+// addedPatch returns a hunk adding n lines of width characters.
+func addedPatch(n, width int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "@@ -0,0 +1,%d @@", n)
+	for i := 0; i < n; i++ {
+		b.WriteString("\n+" + strings.Repeat("x", width))
+	}
+	return b.String()
+}
+
+// addedLines returns the new-file line numbers of the lines patch adds.
+func addedLines(patch string) []int {
+	var lines []int
+	newLine := 0
+	for _, line := range strings.Split(patch, "\n") {
+		if _, start, ok := parseHunkHeader(line); ok {
+			newLine = start
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line, "+"):
+			lines = append(lines, newLine)
+			newLine++
+		case strings.HasPrefix(line, " "):
+			newLine++
+		}
+	}
+	return lines
+}
+
+func TestChunkDiff(t *testing.T) {
+	files := []FileDiff{
+		{Path: "small.go", Patch: addedPatch(3, 20)},
+		{Path: "empty.go"},
+		{Path: "big.go", Patch: addedPatch(200, 40)},
+		{Path: "other.go", Patch: addedPatch(5, 20)},
+	}
+	const budget = 500
+	chunks := ChunkDiff(files, budget)
+	if len(chunks) < 2 {
+		t.Fatalf("got %d chunk(s), want the big file split", len(chunks))
+	}
+	added := map[string]int{}
+	for i, chunk := range chunks {
+		if tokens := EstimateTokens(RenderDiff(chunk)); tokens > budget {
+			t.Errorf("chunk %d is %d tokens, over the budget of %d", i, tokens, budget)
+		}
+		for _, f := range chunk {
+			if f.Path == "empty.go" {
+				t.Errorf("chunk %d contains a file without a patch", i)
+			}
+			added[f.Path] += len(addedLines(f.Patch))
+		}
+	}
+	// Every added line is reviewed exactly once.
+	for path, want := range map[string]int{"small.go": 3, "big.go": 200, "other.go": 5} {
+		if added[path] != want {
+			t.Errorf("%s: %d added lines across chunks, want %d", path, added[path], want)
+		}
+	}
+}
+
+func TestSplitPatchKeepsLineNumbers(t *testing.T) {
+	pieces := splitPatch(FileDiff{Path: "big.go", Patch: addedPatch(100, 40)}, 300)
+	next := 1
+	for _, p := range pieces {
+		for _, n := range addedLines(p.Patch) {
+			if n != next {
+				t.Fatalf("line %d numbered %d after splitting", next, n)
+			}
+			next++
+		}
+	}
+	if next != 101 {
+		t.Errorf("pieces hold %d lines, want 100", next-1)
+	}
+}
+
+func TestSplitPatchLongLines(t *testing.T) {
+	patch := "@@ -1,3 +1,3 @@\n a\n-" + strings.Repeat("x", 400) + "\n+" + strings.Repeat("y", 400) + "\n"
+	for _, p := range splitPatch(FileDiff{Path: "long.go", Patch: patch}, 60) {
+		if len(ParsePatch(p.Patch)) == 0 {
+			t.Errorf("piece holds no diff lines: %q", p.Patch)
+		}
+	}
+}
+
+func TestChunkedSkippedIsPartial(t *testing.T) {
+	inner := &stubReviewer{review: func(_ context.Context, req ReviewRequest) (*Review, error) {
+		if len(req.Partials) > 0 {
+			return nil, fmt.Errorf("no synthesis")
+		}
+		return &Review{Summary: req.Title}, nil
+	}}
+	var files []FileDiff
+	for _, name := range []string{"one.go", "two.go", "three.go"} {
+		files = append(files, FileDiff{Path: name, Patch: addedPatch(12, 30)})
+	}
+	c := &Chunked{Reviewer: inner, Budget: 200, MaxChunks: 2}
+	review, err := c.Review(context.Background(), ReviewRequest{Title: "big", Files: files})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !review.Partial || !strings.Contains(review.Coverage, "1 file(s) were skipped") {
+		t.Errorf("review = %+v, want it partial with a note on the skipped files", review)
+	}
+}
@@ -0,0 +1,338 @@
+package ai
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"codesage/config"
+	"codesage/utils"
+)
+
+// metrics are published on /debug/vars under "ai", keyed "<provider>.<name>".
+var metrics = expvar.NewMap("ai")
+
+// tokenBucket is a simple rate limiter: rate tokens per second refill a
+// bucket of burst tokens and every request takes one.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(perMinute, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: float64(perMinute) / 60, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// Wait blocks until a token is available or ctx is done.
+func (b *tokenBucket) Wait(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		b.refill(time.Now())
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// Available reports the tokens currently in the bucket.
+func (b *tokenBucket) Available() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	return b.tokens
+}
+
+// apiClient sends provider requests with a per-attempt deadline, jittered
+// exponential backoff on 429/5xx (honouring Retry-After) and a per-provider
+// rate limiter shared by every request in the process.
+type apiClient struct {
+	provider   string
+	http       *http.Client
+	limiter    *tokenBucket
+	timeout    time.Duration
+	maxRetries int
+	baseDelay  time.Duration
+	maxDelay   time.Duration
+	// retryHint lets a provider derive the retry delay from a response body
+	// when the server does not send Retry-After.
+	retryHint func(*http.Response) (time.Duration, bool)
+}
+
+type clientOption func(*apiClient)
+
+// withRetryHint installs a provider-specific retry delay parser.
+func withRetryHint(hint func(*http.Response) (time.Duration, bool)) clientOption {
+	return func(c *apiClient) { c.retryHint = hint }
+}
+
+var (
+	clientsMu sync.Mutex
+	clients   = map[string]*apiClient{}
+)
+
+// clientFor returns the shared client for provider, creating it from cfg
+// on first use. Limits are per provider, not per request.
+func clientFor(provider string, cfg *config.Config, opts ...clientOption) *apiClient {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	if c, ok := clients[provider]; ok {
+		return c
+	}
+	perMinute := cfg.AIRateLimit
+	if n, ok := cfg.AIRateLimits[provider]; ok {
+		perMinute = n
+	}
+	c := &apiClient{
+		provider:   provider,
+		http:       &http.Client{},
+		timeout:    cfg.AIRequestTimeout,
+		maxRetries: cfg.AIMaxRetries,
+		baseDelay:  time.Second,
+		maxDelay:   time.Minute,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if cfg.AIHTTPMode != "" {
+		transport, err := NewReplayTransport(cfg.AIHTTPMode, cfg.AIFixturesDir)
+		if err != nil {
+			utils.Errorf("ignoring AI_HTTP_MODE: %v", err)
+		} else {
+			c.http.Transport = transport
+			// Replayed responses are instant; do not throttle or back off.
+			if cfg.AIHTTPMode == ModeReplay {
+				c.maxRetries = 0
+			}
+		}
+	}
+	if perMinute > 0 {
+		c.limiter = newTokenBucket(perMinute, cfg.AIRateBurst)
+		limiter := c.limiter
+		metrics.Set(provider+".limiter_tokens", expvar.Func(func() any { return limiter.Available() }))
+		metrics.Set(provider+".limiter_per_minute", expvar.Func(func() any { return perMinute }))
+	}
+	clients[provider] = c
+	return c
+}
+
+// Do sends req, retrying transient failures. The request body must be
+// replayable (http.NewRequest sets GetBody for in-memory bodies).
+func (c *apiClient) Do(req *http.Request) (*http.Response, error) {
+	return c.do(req, false)
+}
+
+// Stream is Do for streamed responses: the per-attempt timeout limits how
+// long the server may go without sending anything rather than the whole
+// response, so long generations are not cut off.
+func (c *apiClient) Stream(req *http.Request) (*http.Response, error) {
+	return c.do(req, true)
+}
+
+func (c *apiClient) do(req *http.Request, stream bool) (*http.Response, error) {
+	if c == nil {
+		return http.DefaultClient.Do(req)
+	}
+	ctx := req.Context()
+	for attempt := 0; ; attempt++ {
+		if c.limiter != nil {
+			start := time.Now()
+			if err := c.limiter.Wait(ctx); err != nil {
+				return nil, err
+			}
+			if waited := time.Since(start); waited > 10*time.Millisecond {
+				metrics.Add(c.provider+".limiter_waits", 1)
+				metrics.Add(c.provider+".limiter_wait_ms", waited.Milliseconds())
+			}
+		}
+
+		resp, err := c.attempt(req, stream)
+		metrics.Add(c.provider+".requests", 1)
+		if !c.shouldRetry(ctx, resp, err) || attempt >= c.maxRetries {
+			if err != nil {
+				metrics.Add(c.provider+".errors", 1)
+			}
+			return resp, err
+		}
+
+		delay := c.backoff(attempt, resp)
+		if resp != nil {
+			if resp.StatusCode == http.StatusTooManyRequests {
+				metrics.Add(c.provider+".rate_limited", 1)
+			}
+			utils.Infof("%s returned %d, retrying in %s", c.provider, resp.StatusCode, delay)
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		} else {
+			utils.Infof("%s request failed (%v), retrying in %s", c.provider, err, delay)
+		}
+		metrics.Add(c.provider+".retries", 1)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("failed to rewind request body: %v", err)
+			}
+			req.Body = body
+		}
+	}
+}
+
+// attempt performs one request under the per-attempt deadline. The deadline
+// is released when the caller closes the response body.
+func (c *apiClient) attempt(req *http.Request, stream bool) (*http.Response, error) {
+	if c.timeout <= 0 {
+		return c.http.Do(req)
+	}
+	if stream {
+		ctx, cancel := context.WithCancel(req.Context())
+		timer := time.AfterFunc(c.timeout, cancel)
+		resp, err := c.http.Do(req.WithContext(ctx))
+		if err != nil {
+			timer.Stop()
+			cancel()
+			return nil, err
+		}
+		resp.Body = &idleTimeoutBody{ReadCloser: resp.Body, timer: timer, timeout: c.timeout, cancel: cancel}
+		return resp, nil
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), c.timeout)
+	resp, err := c.http.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// idleTimeoutBody cancels a streamed response when no data arrives within
+// timeout of the previous read.
+type idleTimeoutBody struct {
+	io.ReadCloser
+	timer   *time.Timer
+	timeout time.Duration
+	cancel  context.CancelFunc
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.timer.Reset(b.timeout)
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.timer.Stop()
+	b.cancel()
+	return err
+}
+
+func (c *apiClient) shouldRetry(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		// Per-attempt timeouts and network failures are worth another try.
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout, 529:
+		return true
+	}
+	return false
+}
+
+// backoff returns how long to wait before the next attempt: Retry-After if
+// the server sent one, otherwise full-jitter exponential backoff.
+func (c *apiClient) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		d, ok := parseRetryAfter(resp.Header.Get("Retry-After"))
+		if !ok && c.retryHint != nil {
+			d, ok = c.retryHint(resp)
+		}
+		if ok {
+			if d > c.maxDelay {
+				d = c.maxDelay
+			}
+			return d
+		}
+	}
+	ceiling := c.baseDelay << attempt
+	if ceiling <= 0 || ceiling > c.maxDelay {
+		ceiling = c.maxDelay
+	}
+	return time.Duration(rand.Int63n(int64(ceiling))) + 100*time.Millisecond
+}
+
+// parseRetryAfter understands both delta-seconds and HTTP-date values.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
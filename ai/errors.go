@@ -0,0 +1,79 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// APIError is returned when a provider answers with a non-success status.
+type APIError struct {
+	Provider   string
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%s API returned status %d", e.Provider, e.StatusCode)
+	}
+	return fmt.Sprintf("%s API returned status %d: %s", e.Provider, e.StatusCode, e.Message)
+}
+
+// isTransient reports whether err looks like an outage rather than a bad
+// request: 5xx, rate limits and quota errors, timeouts and network failures.
+// Only transient errors count against a provider's circuit breaker.
+func isTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests ||
+			apiErr.StatusCode == http.StatusRequestTimeout ||
+			apiErr.StatusCode >= 500
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// BlockedError is returned when a provider refuses to produce a review,
+// e.g. because its safety filters flagged the prompt or the answer.
+type BlockedError struct {
+	Provider string
+	// Stage is "prompt" when the request itself was rejected and
+	// "response" when generation was stopped before any usable output.
+	Stage  string
+	Reason string
+	// Categories lists the safety categories that triggered the block.
+	Categories []string
+}
+
+func (e *BlockedError) Error() string {
+	msg := fmt.Sprintf("%s blocked the %s (%s)", e.Provider, e.Stage, e.Reason)
+	if len(e.Categories) > 0 {
+		msg += ": " + strings.Join(e.Categories, ", ")
+	}
+	return msg
+}
+
+// EmptyResponseError is returned when a provider finished without writing
+// any review, e.g. a thinking model that spent its whole output budget on
+// thoughts.
+type EmptyResponseError struct {
+	Provider string
+	// FinishReason is the provider's own reason for stopping.
+	FinishReason string
+	// StopReason classifies FinishReason when it is a known limit.
+	StopReason StopReason
+}
+
+func (e *EmptyResponseError) Error() string {
+	return fmt.Sprintf("%s returned no review (finish reason %q)", e.Provider, e.FinishReason)
+}
@@ -0,0 +1,140 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"codesage/config"
+	"codesage/utils"
+)
+
+// breaker is a per-provider circuit breaker. After threshold consecutive
+// transient failures the provider is skipped until the cool-down expires;
+// the next call after that is a trial, and one more failure re-opens it.
+type breaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	threshold int
+	cooldown  time.Duration
+}
+
+func (b *breaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !now.Before(b.openUntil)
+}
+
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.openUntil = time.Time{}
+}
+
+// failure records a transient failure and reports whether the breaker opened.
+func (b *breaker) failure(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = now.Add(b.cooldown)
+		return true
+	}
+	return false
+}
+
+// Breakers outlive a single webhook delivery, so they are kept per provider
+// name for the lifetime of the process.
+var (
+	breakersMu sync.Mutex
+	breakers   = map[string]*breaker{}
+)
+
+func breakerFor(name string, cfg *config.Config) *breaker {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	b, ok := breakers[name]
+	if !ok {
+		b = &breaker{threshold: cfg.AIBreakerThreshold, cooldown: cfg.AIBreakerCooldown}
+		if b.threshold <= 0 {
+			b.threshold = 3
+		}
+		if b.cooldown <= 0 {
+			b.cooldown = 5 * time.Minute
+		}
+		breakers[name] = b
+	}
+	return b
+}
+
+// Chain tries providers in order until one produces a review.
+type Chain struct {
+	reviewers []Reviewer
+	breakers  []*breaker
+}
+
+// NewChain builds a fallback chain from the named providers. Providers that
+// cannot be built (usually a missing API key) are left out with a warning.
+func NewChain(names []string, cfg *config.Config) (*Chain, error) {
+	chain := &Chain{}
+	var errs []error
+	for _, name := range names {
+		r, err := New(name, cfg)
+		if err != nil {
+			utils.Errorf("skipping AI provider %s: %v", name, err)
+			errs = append(errs, err)
+			continue
+		}
+		chain.reviewers = append(chain.reviewers, r)
+		chain.breakers = append(chain.breakers, breakerFor(r.Name(), cfg))
+	}
+	if len(chain.reviewers) == 0 {
+		return nil, fmt.Errorf("no usable AI provider in %v: %w", names, errors.Join(errs...))
+	}
+	return chain, nil
+}
+
+func (c *Chain) Name() string {
+	names := make([]string, len(c.reviewers))
+	for i, r := range c.reviewers {
+		names[i] = r.Name()
+	}
+	return strings.Join(names, " → ")
+}
+
+// Review asks each provider in turn. The returned Review records which
+// provider answered and which ones were skipped or failed before it.
+func (c *Chain) Review(ctx context.Context, req ReviewRequest) (*Review, error) {
+	var errs []error
+	var skipped []string
+	for i, r := range c.reviewers {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		b := c.breakers[i]
+		if !b.allow(time.Now()) {
+			utils.Infof("circuit open for %s, skipping", r.Name())
+			errs = append(errs, fmt.Errorf("%s: circuit open", r.Name()))
+			skipped = append(skipped, r.Name())
+			continue
+		}
+		review, err := r.Review(ctx, req)
+		if err == nil {
+			b.success()
+			review.FallbackFrom = skipped
+			return review, nil
+		}
+		utils.Errorf("AI provider %s failed: %v", r.Name(), err)
+		if isTransient(err) && b.failure(time.Now()) {
+			utils.Errorf("circuit opened for %s", r.Name())
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", r.Name(), err))
+		skipped = append(skipped, r.Name())
+	}
+	return nil, fmt.Errorf("all AI providers failed: %w", errors.Join(errs...))
+}
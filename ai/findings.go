@@ -0,0 +1,227 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"codesage/utils"
+)
+
+// Severity ranks how urgently a finding needs attention.
+type Severity string
+
+const (
+	SeverityInfo     Severity = "info"
+	SeverityMinor    Severity = "minor"
+	SeverityMajor    Severity = "major"
+	SeverityCritical Severity = "critical"
+)
+
+var severityRank = map[Severity]int{SeverityCritical: 0, SeverityMajor: 1, SeverityMinor: 2, SeverityInfo: 3}
+
+// severityAliases maps words models commonly use onto our severities.
+var severityAliases = map[string]Severity{
+	"info": SeverityInfo, "nit": SeverityInfo, "note": SeverityInfo, "suggestion": SeverityInfo,
+	"minor": SeverityMinor, "low": SeverityMinor, "warning": SeverityMinor,
+	"major": SeverityMajor, "medium": SeverityMajor, "high": SeverityMajor, "error": SeverityMajor,
+	"critical": SeverityCritical, "blocker": SeverityCritical,
+}
+
+var categories = map[string]bool{
+	"bug": true, "security": true, "performance": true, "maintainability": true,
+	"style": true, "testing": true, "documentation": true,
+}
+
+// Finding is a single machine-readable review comment.
+type Finding struct {
+	File         string   `json:"file"`
+	StartLine    int      `json:"start_line"`
+	EndLine      int      `json:"end_line"`
+	Severity     Severity `json:"severity"`
+	Category     string   `json:"category"`
+	Message      string   `json:"message"`
+	SuggestedFix string   `json:"suggested_fix,omitempty"`
+	// Rule names the static check that produced the finding, if any.
+	Rule string `json:"rule,omitempty"`
+}
+
+// Report is the JSON document every provider is asked to return.
+type Report struct {
+	Summary  string    `json:"summary"`
+	Findings []Finding `json:"findings"`
+}
+
+var (
+	fencePattern         = regexp.MustCompile("(?s)```(?:json)?\\s*(.*?)```")
+	trailingCommaPattern = regexp.MustCompile(`,\s*([}\]])`)
+)
+
+// ParseReport extracts a Report from model output, repairing the usual
+// mistakes: markdown fences, prose around the object and trailing commas.
+// Findings that fail validation are dropped rather than failing the report.
+func ParseReport(text string) (*Report, error) {
+	candidate := strings.TrimSpace(text)
+	if m := fencePattern.FindStringSubmatch(candidate); m != nil {
+		candidate = m[1]
+	}
+	start, end := strings.Index(candidate, "{"), strings.LastIndex(candidate, "}")
+	if start < 0 || end <= start {
+		return nil, fmt.Errorf("no JSON object in response")
+	}
+	candidate = trailingCommaPattern.ReplaceAllString(candidate[start:end+1], "$1")
+
+	var report Report
+	if err := json.Unmarshal([]byte(candidate), &report); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+	report.Summary = strings.TrimSpace(report.Summary)
+
+	valid := report.Findings[:0]
+	for _, f := range report.Findings {
+		if err := f.normalize(); err != nil {
+			utils.Infof("dropping finding %q: %v", f.Message, err)
+			continue
+		}
+		valid = append(valid, f)
+	}
+	report.Findings = valid
+	if report.Summary == "" && len(report.Findings) == 0 {
+		return nil, fmt.Errorf("response has neither a summary nor findings")
+	}
+	sort.SliceStable(report.Findings, func(i, j int) bool {
+		return severityRank[report.Findings[i].Severity] < severityRank[report.Findings[j].Severity]
+	})
+	return &report, nil
+}
+
+// normalize validates a finding and coerces near-misses into the schema.
+func (f *Finding) normalize() error {
+	f.File = strings.TrimPrefix(strings.TrimSpace(f.File), "b/")
+	f.Message = strings.TrimSpace(f.Message)
+	if f.Message == "" {
+		return fmt.Errorf("empty message")
+	}
+	sev, ok := severityAliases[strings.ToLower(strings.TrimSpace(string(f.Severity)))]
+	if !ok {
+		sev = SeverityMinor
+	}
+	f.Severity = sev
+	f.Category = strings.ToLower(strings.TrimSpace(f.Category))
+	if !categories[f.Category] {
+		f.Category = "maintainability"
+	}
+	if f.StartLine < 0 || f.EndLine < 0 {
+		return fmt.Errorf("negative line range %d-%d", f.StartLine, f.EndLine)
+	}
+	if f.EndLine < f.StartLine {
+		f.EndLine = f.StartLine
+	}
+	return nil
+}
+
+// RenderMarkdown formats a report for a PR comment.
+func RenderMarkdown(report *Report) string {
+	var b strings.Builder
+	if report.Summary != "" {
+		b.WriteString(report.Summary)
+		b.WriteString("\n")
+	}
+	if len(report.Findings) == 0 {
+		b.WriteString("\nNo issues found. 🎉\n")
+		return strings.TrimSpace(b.String())
+	}
+	b.WriteString(RenderFindings("Findings", report.Findings))
+	return strings.TrimSpace(b.String())
+}
+
+// RenderFindings formats findings as a markdown section under heading.
+func RenderFindings(heading string, findings []Finding) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "\n### %s\n", heading)
+	for _, f := range findings {
+		b.WriteString("\n")
+		b.WriteString(renderFinding(f))
+	}
+	return b.String()
+}
+
+var severityIcons = map[Severity]string{
+	SeverityCritical: "🔴", SeverityMajor: "🟠", SeverityMinor: "🟡", SeverityInfo: "🔵",
+}
+
+func renderFinding(f Finding) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "- %s **%s** · %s", severityIcons[f.Severity], f.Severity, f.Category)
+	if f.File != "" {
+		fmt.Fprintf(&b, " · `%s", f.File)
+		switch {
+		case f.StartLine > 0 && f.EndLine > f.StartLine:
+			fmt.Fprintf(&b, ":%d-%d", f.StartLine, f.EndLine)
+		case f.StartLine > 0:
+			fmt.Fprintf(&b, ":%d", f.StartLine)
+		}
+		b.WriteString("`")
+	}
+	if f.Rule != "" {
+		fmt.Fprintf(&b, " · rule `%s`", f.Rule)
+	}
+	fmt.Fprintf(&b, "\n  %s\n", f.Message)
+	if f.SuggestedFix != "" {
+		fmt.Fprintf(&b, "  <details><summary>Suggested fix</summary>\n\n  ```\n  %s\n  ```\n  </details>\n",
+			strings.ReplaceAll(f.SuggestedFix, "\n", "\n  "))
+	}
+	return b.String()
+}
+
+// RenderInline formats a finding as a review comment attached to its
+// lines, so the location is left out.
+func RenderInline(f Finding) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s **%s** · %s", severityIcons[f.Severity], f.Severity, f.Category)
+	if f.Rule != "" {
+		fmt.Fprintf(&b, " · rule `%s`", f.Rule)
+	}
+	fmt.Fprintf(&b, "\n\n%s\n", f.Message)
+	if f.SuggestedFix != "" {
+		fmt.Fprintf(&b, "\n<details><summary>Suggested fix</summary>\n\n```\n%s\n```\n</details>\n", f.SuggestedFix)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// Structured wraps a Reviewer so that its output is parsed into a Report.
+// Malformed output is retried with a repair hint; if the model still cannot
+// produce valid JSON the raw text is kept so the PR gets a review anyway.
+type Structured struct {
+	Reviewer
+	Retries int
+}
+
+// Review runs the wrapped provider and validates its output.
+func (s *Structured) Review(ctx context.Context, req ReviewRequest) (*Review, error) {
+	var review *Review
+	var usage Usage
+	for attempt := 0; attempt <= s.Retries; attempt++ {
+		var err error
+		review, err = s.Reviewer.Review(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		usage.Add(review.Usage)
+		review.Usage = usage
+
+		report, err := ParseReport(review.Text)
+		if err == nil {
+			review.Summary = report.Summary
+			review.Findings = report.Findings
+			review.Text = RenderMarkdown(report)
+			return review, nil
+		}
+		utils.Errorf("%s returned malformed findings (attempt %d): %v", review.Provider, attempt+1, err)
+		req.RepairHint = err.Error()
+	}
+	return review, nil
+}
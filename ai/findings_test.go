@@ -0,0 +1,107 @@
+package ai
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseReport(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		summary string
+		files   []string
+	}{
+		{
+			name:    "plain",
+			text:    `{"summary": "Looks fine.", "findings": []}`,
+			summary: "Looks fine.",
+		},
+		{
+			name: "fenced with prose",
+			text: "Here is my review:\n```json\n" +
+				`{"summary": "One bug.", "findings": [{"file": "b/main.go", "start_line": 3, "end_line": 3, "severity": "major", "category": "bug", "message": "nil map"}]}` +
+				"\n```\nThanks!",
+			summary: "One bug.",
+			files:   []string{"main.go"},
+		},
+		{
+			name:    "trailing commas",
+			text:    `{"summary": "s", "findings": [{"file": "a.go", "start_line": 1, "severity": "low", "message": "m",},],}`,
+			summary: "s",
+			files:   []string{"a.go"},
+		},
+		{
+			name: "sorted by severity",
+			text: `{"summary": "s", "findings": [
+				{"file": "info.go", "severity": "nit", "message": "m"},
+				{"file": "critical.go", "severity": "blocker", "message": "m"},
+				{"file": "major.go", "severity": "high", "message": "m"}]}`,
+			summary: "s",
+			files:   []string{"critical.go", "major.go", "info.go"},
+		},
+		{
+			name: "invalid findings dropped",
+			text: `{"summary": "s", "findings": [
+				{"file": "empty.go", "severity": "major", "message": "  "},
+				{"file": "negative.go", "start_line": -1, "message": "m"},
+				{"file": "ok.go", "message": "m"}]}`,
+			summary: "s",
+			files:   []string{"ok.go"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := ParseReport(tt.text)
+			if err != nil {
+				t.Fatalf("ParseReport: %v", err)
+			}
+			if report.Summary != tt.summary {
+				t.Errorf("summary = %q, want %q", report.Summary, tt.summary)
+			}
+			var files []string
+			for _, f := range report.Findings {
+				files = append(files, f.File)
+			}
+			if strings.Join(files, ",") != strings.Join(tt.files, ",") {
+				t.Errorf("files = %v, want %v", files, tt.files)
+			}
+		})
+	}
+}
+
+func TestParseReportNormalizes(t *testing.T) {
+	report, err := ParseReport(`{"summary": "", "findings": [{"file": " x.go ", "start_line": 9, "end_line": 2, "severity": "Weird", "category": "Nonsense", "message": "m"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := report.Findings[0]
+	if f.File != "x.go" || f.EndLine != 9 || f.Severity != SeverityMinor || f.Category != "maintainability" {
+		t.Errorf("finding not normalized: %+v", f)
+	}
+}
+
+func TestParseReportErrors(t *testing.T) {
+	for _, text := range []string{
+		"I could not review this change.",
+		`{"summary": "unterminated`,
+		`{"summary": "", "findings": []}`,
+	} {
+		if _, err := ParseReport(text); err == nil {
+			t.Errorf("ParseReport(%q) succeeded, want an error", text)
+		}
+	}
+}
+
+func TestRenderInline(t *testing.T) {
+	got := RenderInline(Finding{File: "a.go", StartLine: 4, Severity: SeverityMajor, Category: "bug", Rule: "todo", Message: "Fix it.", SuggestedFix: "x := 1"})
+	for _, want := range []string{"**major** · bug · rule `todo`", "Fix it.", "Suggested fix", "x := 1"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("RenderInline missing %q:\n%s", want, got)
+		}
+	}
+	// The comment sits on the line, so the location is not repeated.
+	if strings.Contains(got, "a.go") {
+		t.Errorf("RenderInline repeats the location:\n%s", got)
+	}
+}
@@ -3,99 +3,405 @@ package ai
 
 import (
     "bytes"
+    "context"
     "encoding/json"
     "fmt"
+    "io"
     "net/http"
-    "os"
+    "sort"
     "strings"
+    "codesage/config"
 )
 
+type GeminiPart struct {
+    Text string `json:"text,omitempty"`
+    // Thought marks a thinking-model reasoning part rather than the answer
+    Thought bool `json:"thought,omitempty"`
+    // ThoughtSignature must be sent back with the part it came with
+    ThoughtSignature string                  `json:"thoughtSignature,omitempty"`
+    FunctionCall     *GeminiFunctionCall     `json:"functionCall,omitempty"`
+    FunctionResponse *GeminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type GeminiFunctionCall struct {
+    Name string          `json:"name"`
+    Args json.RawMessage `json:"args,omitempty"`
+}
+
+type GeminiFunctionResponse struct {
+    Name     string         `json:"name"`
+    Response map[string]any `json:"response"`
+}
+
+type GeminiFunctionDeclaration struct {
+    Name        string         `json:"name"`
+    Description string         `json:"description"`
+    Parameters  map[string]any `json:"parameters,omitempty"`
+}
+
+type GeminiTool struct {
+    FunctionDeclarations []GeminiFunctionDeclaration `json:"functionDeclarations"`
+}
+
+type GeminiFunctionCallingConfig struct {
+    Mode string `json:"mode"`
+}
+
+type GeminiToolConfig struct {
+    FunctionCallingConfig GeminiFunctionCallingConfig `json:"functionCallingConfig"`
+}
+
+type GeminiContent struct {
+    Role  string       `json:"role,omitempty"`
+    Parts []GeminiPart `json:"parts"`
+}
+
+type GeminiGenerationConfig struct {
+    Temperature      *float64 `json:"temperature,omitempty"`
+    TopP             *float64 `json:"topP,omitempty"`
+    MaxOutputTokens  int      `json:"maxOutputTokens,omitempty"`
+    StopSequences    []string `json:"stopSequences,omitempty"`
+    ResponseMimeType string   `json:"responseMimeType,omitempty"`
+}
+
+type GeminiSafetySetting struct {
+    Category  string `json:"category"`
+    Threshold string `json:"threshold"`
+}
+
+type GeminiSafetyRating struct {
+    Category    string `json:"category"`
+    Probability string `json:"probability"`
+    Blocked     bool   `json:"blocked,omitempty"`
+}
+
 // Request payload
 type GeminiRequest struct {
-    Contents []struct {
-        Parts []struct {
-            Text string `json:"text"`
-        } `json:"parts"`
-    } `json:"contents"`
+    SystemInstruction *GeminiContent          `json:"systemInstruction,omitempty"`
+    Contents          []GeminiContent         `json:"contents"`
+    GenerationConfig  *GeminiGenerationConfig `json:"generationConfig,omitempty"`
+    SafetySettings    []GeminiSafetySetting   `json:"safetySettings,omitempty"`
+    Tools             []GeminiTool            `json:"tools,omitempty"`
+    ToolConfig        *GeminiToolConfig       `json:"toolConfig,omitempty"`
+}
+
+type GeminiCandidate struct {
+    Content       GeminiContent        `json:"content"`
+    FinishReason  string               `json:"finishReason"`
+    SafetyRatings []GeminiSafetyRating `json:"safetyRatings"`
 }
 
 // Response payload
 type GeminiResponse struct {
-    Candidates []struct {
-        Content struct {
-            Parts []struct {
-                Text string `json:"text"`
-            } `json:"parts"`
-        } `json:"content"`
-    } `json:"candidates"`
+    Candidates     []GeminiCandidate `json:"candidates"`
+    PromptFeedback *struct {
+        BlockReason   string               `json:"blockReason"`
+        SafetyRatings []GeminiSafetyRating `json:"safetyRatings"`
+    } `json:"promptFeedback"`
+    UsageMetadata struct {
+        PromptTokenCount     int `json:"promptTokenCount"`
+        CandidatesTokenCount int `json:"candidatesTokenCount"`
+        ThoughtsTokenCount   int `json:"thoughtsTokenCount"`
+        TotalTokenCount      int `json:"totalTokenCount"`
+    } `json:"usageMetadata"`
+}
+
+// defaultGeminiSafety relaxes Gemini's filters for code review: diffs that
+// touch exploits, injection or credential handling are routinely flagged as
+// dangerous content under the default thresholds.
+var defaultGeminiSafety = map[string]string{
+    "HARM_CATEGORY_HARASSMENT":        "BLOCK_ONLY_HIGH",
+    "HARM_CATEGORY_HATE_SPEECH":       "BLOCK_ONLY_HIGH",
+    "HARM_CATEGORY_SEXUALLY_EXPLICIT": "BLOCK_ONLY_HIGH",
+    "HARM_CATEGORY_DANGEROUS_CONTENT": "BLOCK_NONE",
+}
+
+// GeminiReviewer reviews diffs with Google's Gemini API
+type GeminiReviewer struct {
+    APIKey string
+    Params config.ModelParams
+    Safety []GeminiSafetySetting
+    client *apiClient
+}
+
+func init() {
+    Register("gemini", NewGeminiReviewer)
 }
 
-// AnalyzeWithGemini sends diff + title to Gemini and returns analysis
-func AnalyzeWithGemini(diff, title string) (string, error) {
-    apiKey := os.Getenv("GEMINI_API_KEY")
-    if apiKey == "" {
-        return "", fmt.Errorf("Gemini API key missing")
+// NewGeminiReviewer builds a Gemini provider from the configured API key
+func NewGeminiReviewer(cfg *config.Config) (Reviewer, error) {
+    if cfg.GeminiKey == "" {
+        return nil, fmt.Errorf("Gemini API key missing")
     }
-    
-    // Limit diff size to avoid hitting API limits
-    if len(diff) > 8000 {
-        diff = diff[:8000] + "\n... (truncated for analysis)"
+    params := cfg.Models["gemini"]
+    if params.Model == "" {
+        return nil, fmt.Errorf("Gemini model missing")
     }
-    
-  prompt := fmt.Sprintf(`Review this code change like a friendly senior developer:
+    return &GeminiReviewer{
+        APIKey: cfg.GeminiKey,
+        Params: params,
+        Safety: geminiSafetySettings(cfg.GeminiSafety),
+        client: clientFor("gemini", cfg),
+    }, nil
+}
+
+// geminiSafetySettings applies overrides such as
+// {"dangerous_content": "block_only_high"} to the defaults.
+func geminiSafetySettings(overrides map[string]string) []GeminiSafetySetting {
+    thresholds := map[string]string{}
+    for category, threshold := range defaultGeminiSafety {
+        thresholds[category] = threshold
+    }
+    for category, threshold := range overrides {
+        category = strings.ToUpper(category)
+        if !strings.HasPrefix(category, "HARM_CATEGORY_") {
+            category = "HARM_CATEGORY_" + category
+        }
+        thresholds[category] = strings.ToUpper(threshold)
+    }
+    settings := make([]GeminiSafetySetting, 0, len(thresholds))
+    for category, threshold := range thresholds {
+        settings = append(settings, GeminiSafetySetting{Category: category, Threshold: threshold})
+    }
+    sort.Slice(settings, func(i, j int) bool { return settings[i].Category < settings[j].Category })
+    return settings
+}
 
-**%s**
+// blockedCategories names the safety categories behind a block, e.g.
+// "dangerous_content".
+func blockedCategories(ratings []GeminiSafetyRating) []string {
+    var out []string
+    for _, r := range ratings {
+        if r.Blocked || r.Probability == "HIGH" {
+            out = append(out, strings.ToLower(strings.TrimPrefix(r.Category, "HARM_CATEGORY_")))
+        }
+    }
+    return out
+}
 
-%s
+func (g *GeminiReviewer) Name() string { return "gemini" }
 
-Give me 2-3 key points about this change - what's good, what needs attention, any quick suggestions. Keep it conversational and practical.`, title, diff)  
+// Review sends diff + title to Gemini and returns analysis. When the request
+// offers tools, Gemini may call them before answering.
+func (g *GeminiReviewer) Review(ctx context.Context, req ReviewRequest) (*Review, error) {
     // Build request
     reqBody := GeminiRequest{
-        Contents: []struct {
-            Parts []struct {
-                Text string `json:"text"`
-            } `json:"parts"`
-        }{
-            {
-                Parts: []struct {
-                    Text string `json:"text"`
-                }{
-                    {Text: prompt},
-                },
-            },
+        SystemInstruction: &GeminiContent{Parts: []GeminiPart{{Text: systemPrompt(req, g.Params)}}},
+        Contents: []GeminiContent{
+            {Role: "user", Parts: []GeminiPart{{Text: reviewPrompt(req)}}},
         },
+        GenerationConfig: &GeminiGenerationConfig{
+            Temperature:      g.Params.Temperature,
+            TopP:             g.Params.TopP,
+            MaxOutputTokens:  g.Params.MaxTokens,
+            StopSequences:    g.Params.StopSequences,
+            ResponseMimeType: "application/json",
+        },
+        SafetySettings: g.Safety,
+    }
+    
+    session := newToolSession(req)
+    onProgress := req.OnProgress
+    if session != nil {
+        reqBody.Tools = []GeminiTool{{FunctionDeclarations: geminiFunctions(session)}}
+        // Gemini rejects function calling combined with a JSON response type
+        reqBody.GenerationConfig.ResponseMimeType = ""
+        // Only the final answer is worth showing, and which turn that is is
+        // only known afterwards
+        onProgress = nil
+    }
+    
+    var geminiResp GeminiResponse
+    var usage Usage
+    for {
+        var err error
+        geminiResp, err = g.generate(ctx, reqBody, onProgress)
+        if err != nil {
+            return nil, err
+        }
+        // Thinking tokens are billed as output
+        meta := geminiResp.UsageMetadata
+        usage.Add(Usage{PromptTokens: meta.PromptTokenCount, CompletionTokens: meta.CandidatesTokenCount + meta.ThoughtsTokenCount})
+        
+        calls := geminiCalls(geminiResp)
+        if session == nil || len(calls) == 0 {
+            break
+        }
+        if session.exhausted() {
+            return nil, fmt.Errorf("Gemini kept calling tools after the budget of %d steps was spent", session.maxSteps)
+        }
+        // Echo the model's turn, then answer every call it made
+        reqBody.Contents = append(reqBody.Contents, geminiResp.Candidates[0].Content)
+        results := GeminiContent{Role: "user"}
+        for _, call := range calls {
+            out := session.run(ctx, ToolCall{Name: call.Name, Args: call.Args})
+            results.Parts = append(results.Parts, GeminiPart{FunctionResponse: &GeminiFunctionResponse{
+                Name:     call.Name,
+                Response: map[string]any{"content": out},
+            }})
+        }
+        if session.exhausted() {
+            results.Parts = append(results.Parts, GeminiPart{Text: finalNudge})
+            reqBody.ToolConfig = &GeminiToolConfig{FunctionCallingConfig: GeminiFunctionCallingConfig{Mode: "NONE"}}
+        }
+        reqBody.Contents = append(reqBody.Contents, results)
+    }
+    
+    // A blocked prompt comes back with feedback and no candidates
+    if fb := geminiResp.PromptFeedback; fb != nil && fb.BlockReason != "" {
+        return nil, &BlockedError{Provider: "Gemini", Stage: "prompt", Reason: fb.BlockReason, Categories: blockedCategories(fb.SafetyRatings)}
+    }
+    if len(geminiResp.Candidates) == 0 {
+        return nil, fmt.Errorf("no response from Gemini")
+    }
+    candidate := geminiResp.Candidates[0]
+    
+    response := strings.TrimSpace(answerText(candidate))
+    
+    review := &Review{
+        Text:     response,
+        Provider: g.Name(),
+        Model:    g.Params.Model,
+        Usage:    usage,
+    }
+    switch candidate.FinishReason {
+    case "", "STOP", "FINISH_REASON_UNSPECIFIED":
+    case "MAX_TOKENS":
+        review.Truncated, review.StopReason = true, StopMaxTokens
+    case "SAFETY", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII":
+        review.Truncated, review.StopReason = true, StopSafety
+    case "RECITATION":
+        review.Truncated, review.StopReason = true, StopRecitation
     }
     
+    if response == "" {
+        if review.StopReason == StopSafety || review.StopReason == StopRecitation {
+            return nil, &BlockedError{Provider: "Gemini", Stage: "response", Reason: candidate.FinishReason, Categories: blockedCategories(candidate.SafetyRatings)}
+        }
+        // MAX_TOKENS, OTHER and MALFORMED_FUNCTION_CALL all end up here
+        return nil, &EmptyResponseError{Provider: "Gemini", FinishReason: candidate.FinishReason, StopReason: review.StopReason}
+    }
+    return review, nil
+}
+
+// generate makes one generateContent call, streaming when onProgress is set
+func (g *GeminiReviewer) generate(ctx context.Context, reqBody GeminiRequest, onProgress func(Progress)) (GeminiResponse, error) {
+    var geminiResp GeminiResponse
     jsonData, err := json.Marshal(reqBody)
     if err != nil {
-        return "", fmt.Errorf("failed to marshal request: %v", err)
+        return geminiResp, fmt.Errorf("failed to marshal request: %v", err)
     }
     
-url := "https://generativelanguage.googleapis.com/v1beta/models/gemini-2.5-pro:generateContent?key=" + apiKey
+    stream := onProgress != nil
+    url := "https://generativelanguage.googleapis.com/v1beta/models/" + g.Params.Model + ":generateContent"
+    if stream {
+        url = "https://generativelanguage.googleapis.com/v1beta/models/" + g.Params.Model + ":streamGenerateContent?alt=sse"
+    }
+    
+    httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+    if err != nil {
+        return geminiResp, fmt.Errorf("failed to build request: %v", err)
+    }
+    httpReq.Header.Set("Content-Type", "application/json")
+    // The key goes in a header: transport errors quote the URL, and those
+    // end up in logs and the usage ledger
+    httpReq.Header.Set("x-goog-api-key", g.APIKey)
     
-    resp, err := http.Post(url, "application/json", bytes.NewBuffer(jsonData))
+    var resp *http.Response
+    if stream {
+        resp, err = g.client.Stream(httpReq)
+    } else {
+        resp, err = g.client.Do(httpReq)
+    }
     if err != nil {
-        return "", fmt.Errorf("failed to call Gemini API: %v", err)
+        return geminiResp, fmt.Errorf("failed to call Gemini API: %w", err)
     }
     defer resp.Body.Close()
     
     if resp.StatusCode != http.StatusOK {
-        return "", fmt.Errorf("Gemini API returned status %d", resp.StatusCode)
+        body, _ := io.ReadAll(resp.Body)
+        return geminiResp, &APIError{Provider: "Gemini", StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(body))}
     }
     
-    var geminiResp GeminiResponse
+    if stream {
+        return readGeminiStream(resp.Body, onProgress)
+    }
     if err := json.NewDecoder(resp.Body).Decode(&geminiResp); err != nil {
-        return "", fmt.Errorf("failed to decode response: %v", err)
+        return geminiResp, fmt.Errorf("failed to decode response: %v", err)
     }
-    
-    if len(geminiResp.Candidates) == 0 || len(geminiResp.Candidates[0].Content.Parts) == 0 {
-        return "", fmt.Errorf("no response from Gemini")
+    return geminiResp, nil
+}
+
+// geminiFunctions declares the session's tools to Gemini
+func geminiFunctions(s *toolSession) []GeminiFunctionDeclaration {
+    decls := make([]GeminiFunctionDeclaration, len(s.order))
+    for i, t := range s.order {
+        decls[i] = GeminiFunctionDeclaration{Name: t.Name, Description: t.Description, Parameters: t.Parameters}
     }
-    
-    response := geminiResp.Candidates[0].Content.Parts[0].Text
-    
-    // Clean up the response
-    response = strings.TrimSpace(response)
-    
-    return response, nil
-}
\ No newline at end of file
+    return decls
+}
+
+// geminiCalls returns the function calls in the first candidate
+func geminiCalls(resp GeminiResponse) []*GeminiFunctionCall {
+    if len(resp.Candidates) == 0 {
+        return nil
+    }
+    var calls []*GeminiFunctionCall
+    for _, part := range resp.Candidates[0].Content.Parts {
+        if part.FunctionCall != nil {
+            calls = append(calls, part.FunctionCall)
+        }
+    }
+    return calls
+}
+
+// answerText joins a candidate's parts. Long answers arrive in several
+// parts and thinking models add thought parts, which are not the answer.
+func answerText(c GeminiCandidate) string {
+    var text strings.Builder
+    for _, part := range c.Content.Parts {
+        if !part.Thought {
+            text.WriteString(part.Text)
+        }
+    }
+    return text.String()
+}
+
+// readGeminiStream folds a streamGenerateContent response into the shape
+// generateContent returns, reporting the answer so far after every event.
+// Each event carries new parts; finish reason, ratings and usage come with
+// the last one.
+func readGeminiStream(body io.Reader, onProgress func(Progress)) (GeminiResponse, error) {
+    var out GeminiResponse
+    var text strings.Builder
+    err := readSSE(body, func(data []byte) error {
+        var event GeminiResponse
+        if err := json.Unmarshal(data, &event); err != nil {
+            return fmt.Errorf("failed to decode stream event: %v", err)
+        }
+        if event.PromptFeedback != nil {
+            out.PromptFeedback = event.PromptFeedback
+        }
+        if event.UsageMetadata.TotalTokenCount > 0 {
+            out.UsageMetadata = event.UsageMetadata
+        }
+        if len(event.Candidates) == 0 {
+            return nil
+        }
+        if len(out.Candidates) == 0 {
+            out.Candidates = []GeminiCandidate{{}}
+        }
+        c, e := &out.Candidates[0], event.Candidates[0]
+        c.Content.Parts = append(c.Content.Parts, e.Content.Parts...)
+        if e.FinishReason != "" {
+            c.FinishReason = e.FinishReason
+        }
+        if len(e.SafetyRatings) > 0 {
+            c.SafetyRatings = e.SafetyRatings
+        }
+        text.WriteString(answerText(e))
+        onProgress(Progress{Text: text.String()})
+        return nil
+    })
+    return out, err
+}
@@ -2,73 +2,154 @@ package ai
 
 import (
     "bytes"
+    "context"
     "encoding/json"
     "fmt"
-    "io/ioutil"
+    "io"
     "net/http"
-    "os"
     "strings"
+    "time"
+    "codesage/config"
 )
 
 type HFRequest struct {
-    Inputs string `json:"inputs"`
+    Inputs     string        `json:"inputs"`
+    Parameters *HFParameters `json:"parameters,omitempty"`
 }
 
-func AnalyzeWithHFCodeReview(diff, title string) (string, error) {
-    apiKey := os.Getenv("HF_API_KEY")
-    if apiKey == "" {
-        return "", fmt.Errorf("Hugging Face API key missing")
-    }
+// HFParameters are the text-generation task parameters
+type HFParameters struct {
+    MaxNewTokens   int      `json:"max_new_tokens,omitempty"`
+    Temperature    *float64 `json:"temperature,omitempty"`
+    TopP           *float64 `json:"top_p,omitempty"`
+    Stop           []string `json:"stop,omitempty"`
+    ReturnFullText bool     `json:"return_full_text"`
+}
 
-    // Truncate diff if too long
-    if len(diff) > 8000 {
-        diff = diff[:8000] + "\n... (truncated)"
-    }
+// HFGeneration is one element of the text-generation response array
+type HFGeneration struct {
+    GeneratedText string `json:"generated_text"`
+}
 
-    // Construct prompt similar to your Gemini version
-    prompt := fmt.Sprintf(`You are CodeSage AI, a helpful code review assistant.
+// HFError is returned on failures, including while the model is loading
+type HFError struct {
+    Error         string  `json:"error"`
+    EstimatedTime float64 `json:"estimated_time"`
+}
 
-PR Title: %s
+// HFReviewer reviews diffs with the Hugging Face Inference API
+type HFReviewer struct {
+    APIKey string
+    Params config.ModelParams
+    client *apiClient
+}
 
-Code Changes:
-%s
+func init() {
+    Register("huggingface", NewHFReviewer)
+}
 
-Please provide:
-- Summary
-- What's Good
-- Potential Issues
-- Security Considerations
-- Performance & Best Practices
-- Suggestions`, title, diff)
+// NewHFReviewer builds a Hugging Face provider from the configured API key
+func NewHFReviewer(cfg *config.Config) (Reviewer, error) {
+    if cfg.HuggingFaceKey == "" {
+        return nil, fmt.Errorf("Hugging Face API key missing")
+    }
+    params := cfg.Models["huggingface"]
+    if params.Model == "" {
+        return nil, fmt.Errorf("Hugging Face model missing")
+    }
+    return &HFReviewer{APIKey: cfg.HuggingFaceKey, Params: params, client: clientFor("huggingface", cfg, withRetryHint(hfLoadingDelay))}, nil
+}
 
-    modelID := "meta-llama/CodeLlama-7b-Instruct-hf"
-    url := "https://api-inference.huggingface.co/models/" + modelID
+func (h *HFReviewer) Name() string { return "huggingface" }
 
-    reqBody := HFRequest{Inputs: prompt}
+// Review sends diff + title to a Hugging Face hosted code model
+func (h *HFReviewer) Review(ctx context.Context, req ReviewRequest) (*Review, error) {
+    prompt := systemPrompt(req, h.Params) + "\n\n" + reviewPrompt(req)
+
+    url := "https://api-inference.huggingface.co/models/" + h.Params.Model
+
+    reqBody := HFRequest{
+        Inputs: prompt,
+        Parameters: &HFParameters{
+            MaxNewTokens:   h.Params.MaxTokens,
+            Temperature:    h.Params.Temperature,
+            TopP:           h.Params.TopP,
+            Stop:           h.Params.StopSequences,
+            ReturnFullText: false,
+        },
+    }
     jsonData, err := json.Marshal(reqBody)
     if err != nil {
-        return "", fmt.Errorf("failed to marshal request: %v", err)
+        return nil, fmt.Errorf("failed to marshal request: %v", err)
     }
 
-    req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+    httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
     if err != nil {
-        return "", fmt.Errorf("failed to build request: %v", err)
+        return nil, fmt.Errorf("failed to build request: %v", err)
     }
-    req.Header.Set("Authorization", "Bearer "+apiKey)
-    req.Header.Set("Content-Type", "application/json")
+    httpReq.Header.Set("Authorization", "Bearer "+h.APIKey)
+    httpReq.Header.Set("Content-Type", "application/json")
 
-    client := &http.Client{}
-    resp, err := client.Do(req)
+    resp, err := h.client.Do(httpReq)
     if err != nil {
-        return "", fmt.Errorf("Hugging Face API call failed: %v", err)
+        return nil, fmt.Errorf("Hugging Face API call failed: %w", err)
     }
     defer resp.Body.Close()
 
-    bodyBytes, _ := ioutil.ReadAll(resp.Body)
+    bodyBytes, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read response: %v", err)
+    }
     if resp.StatusCode != http.StatusOK {
-        return "", fmt.Errorf("HF API error: %d - %s", resp.StatusCode, string(bodyBytes))
+        var hfErr HFError
+        if json.Unmarshal(bodyBytes, &hfErr) == nil && hfErr.Error != "" {
+            msg := hfErr.Error
+            if hfErr.EstimatedTime > 0 {
+                msg = fmt.Sprintf("%s (estimated %.0fs until ready)", msg, hfErr.EstimatedTime)
+            }
+            return nil, &APIError{Provider: "HF", StatusCode: resp.StatusCode, Message: msg}
+        }
+        return nil, &APIError{Provider: "HF", StatusCode: resp.StatusCode, Message: string(bodyBytes)}
     }
 
-    result := string(bodyBytes)
-    return strings.TrimSpace(result), nil
+    text, err := parseHFGeneration(bodyBytes)
+    if err != nil {
+        return nil, err
+    }
+    // Some endpoints ignore return_full_text and echo the prompt anyway
+    text = strings.TrimSpace(strings.TrimPrefix(text, prompt))
+    if text == "" {
+        return nil, fmt.Errorf("no response from Hugging Face")
+    }
+    return &Review{Text: text, Provider: h.Name(), Model: h.Params.Model}, nil
+}
+
+// parseHFGeneration accepts both the array form `[{"generated_text": ...}]`
+// and the single-object form some endpoints return
+func parseHFGeneration(body []byte) (string, error) {
+    var list []HFGeneration
+    if err := json.Unmarshal(body, &list); err == nil {
+        if len(list) == 0 {
+            return "", fmt.Errorf("empty response from Hugging Face")
+        }
+        return list[0].GeneratedText, nil
+    }
+    var single HFGeneration
+    if err := json.Unmarshal(body, &single); err != nil {
+        return "", fmt.Errorf("failed to decode response: %v", err)
+    }
+    return single.GeneratedText, nil
+}
+
+// hfLoadingDelay reads estimated_time from a "model is loading" 503 so the
+// retry waits for the model instead of backing off blindly
+func hfLoadingDelay(resp *http.Response) (time.Duration, bool) {
+    if resp.StatusCode != http.StatusServiceUnavailable {
+        return 0, false
+    }
+    var hfErr HFError
+    if err := json.NewDecoder(resp.Body).Decode(&hfErr); err != nil || hfErr.EstimatedTime <= 0 {
+        return 0, false
+    }
+    return time.Duration(hfErr.EstimatedTime * float64(time.Second)), true
 }
@@ -0,0 +1,161 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+
+	"codesage/config"
+)
+
+// defaultMockTemplate reports one info finding on the first added line of
+// every file, which is enough to exercise the whole comment pipeline.
+const defaultMockTemplate = `{
+  "summary": {{printf "Mock review of %d file(s) for %s." (len .Files) .Title | json}},
+  "findings": [
+    {{- range $i, $f := .Files}}{{if $i}},{{end}}
+    {"file": {{json $f.Path}}, "start_line": {{firstAddedLine $f.Patch}}, "end_line": {{firstAddedLine $f.Patch}},
+     "severity": "info", "category": "maintainability", "message": {{printf "Mock finding for %s." $f.Path | json}}}
+    {{- end}}
+  ]
+}`
+
+// MockReviewer is a deterministic provider for tests and local development.
+// It replays Responses in order (repeating the last one) or, when there are
+// none, renders Template with the ReviewRequest.
+type MockReviewer struct {
+	Responses []string
+	Template  *template.Template
+
+	mu    sync.Mutex
+	calls int
+}
+
+func init() {
+	Register("mock", NewMockReviewer)
+}
+
+var mockFuncs = template.FuncMap{
+	"firstAddedLine": firstAddedLine,
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// NewMockReviewer builds a mock provider. AI_MOCK_SCRIPT may name a JSON
+// file holding an array of scripted responses; AI_MOCK_TEMPLATE a
+// text/template file rendered against the request instead.
+func NewMockReviewer(cfg *config.Config) (Reviewer, error) {
+	m := &MockReviewer{}
+	if cfg.AIMockScript != "" {
+		data, err := os.ReadFile(cfg.AIMockScript)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read mock script: %v", err)
+		}
+		var responses []json.RawMessage
+		if err := json.Unmarshal(data, &responses); err != nil {
+			return nil, fmt.Errorf("mock script must be a JSON array: %v", err)
+		}
+		for _, r := range responses {
+			// Entries may be JSON strings (raw model output) or report objects.
+			var s string
+			if json.Unmarshal(r, &s) != nil {
+				s = string(r)
+			}
+			m.Responses = append(m.Responses, s)
+		}
+		return m, nil
+	}
+	text := defaultMockTemplate
+	if cfg.AIMockTemplate != "" {
+		data, err := os.ReadFile(cfg.AIMockTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read mock template: %v", err)
+		}
+		text = string(data)
+	}
+	tmpl, err := template.New("mock").Funcs(mockFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mock template: %v", err)
+	}
+	m.Template = tmpl
+	return m, nil
+}
+
+func (m *MockReviewer) Name() string { return "mock" }
+
+// Calls reports how many reviews the mock has served.
+func (m *MockReviewer) Calls() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.calls
+}
+
+// Review returns the next scripted response or the rendered template.
+func (m *MockReviewer) Review(ctx context.Context, req ReviewRequest) (*Review, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	call := m.calls
+	m.calls++
+	m.mu.Unlock()
+
+	var text string
+	switch {
+	case len(m.Responses) > 0:
+		text = m.Responses[min(call, len(m.Responses)-1)]
+	case len(req.Partials) > 0:
+		// Synthesis pass: merge the partial reports verbatim.
+		merged := mergeReports(&Review{}, req.Partials)
+		data, err := json.Marshal(Report{Summary: merged.Summary, Findings: merged.Findings})
+		if err != nil {
+			return nil, err
+		}
+		text = string(data)
+	case m.Template != nil:
+		if len(req.Files) == 0 && req.Diff != "" {
+			req.Files = []FileDiff{{Path: "diff", Patch: req.Diff}}
+		}
+		var b strings.Builder
+		if err := m.Template.Execute(&b, req); err != nil {
+			return nil, fmt.Errorf("mock template failed: %v", err)
+		}
+		text = b.String()
+	default:
+		return nil, fmt.Errorf("mock provider has nothing to return")
+	}
+	if req.OnProgress != nil {
+		req.OnProgress(Progress{Text: text})
+	}
+	return &Review{
+		Text:     text,
+		Provider: m.Name(),
+		Model:    "mock",
+		Usage:    Usage{PromptTokens: EstimateTokens(reviewPrompt(req)), CompletionTokens: EstimateTokens(text)},
+	}, nil
+}
+
+// firstAddedLine returns the new-file line number of the first added line
+// in patch, or 0 when there is none.
+func firstAddedLine(patch string) int {
+	newLine := 0
+	for _, line := range strings.Split(patch, "\n") {
+		if _, start, ok := parseHunkHeader(line); ok {
+			newLine = start
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line, "+"):
+			return newLine
+		case strings.HasPrefix(line, " "):
+			newLine++
+		}
+	}
+	return 0
+}
@@ -0,0 +1,133 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"codesage/config"
+)
+
+// OllamaRequest is the /api/chat request payload.
+type OllamaRequest struct {
+	Model    string          `json:"model"`
+	Messages []OpenAIMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Format   string          `json:"format,omitempty"`
+	Options  *OllamaOptions  `json:"options,omitempty"`
+}
+
+// OllamaOptions are the model parameters Ollama accepts per request.
+type OllamaOptions struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	NumPredict  int      `json:"num_predict,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+}
+
+// OllamaResponse is the non-streaming /api/chat response payload.
+type OllamaResponse struct {
+	Model           string        `json:"model"`
+	Message         OpenAIMessage `json:"message"`
+	Done            bool          `json:"done"`
+	DoneReason      string        `json:"done_reason"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+	Error           string        `json:"error,omitempty"`
+}
+
+// OllamaReviewer reviews diffs with a local Ollama daemon, so no code
+// leaves the machine.
+type OllamaReviewer struct {
+	Host   string
+	Params config.ModelParams
+	client *apiClient
+}
+
+func init() {
+	Register("ollama", NewOllamaReviewer)
+}
+
+// NewOllamaReviewer builds an Ollama provider from the configured host and model.
+func NewOllamaReviewer(cfg *config.Config) (Reviewer, error) {
+	if cfg.OllamaHost == "" {
+		return nil, fmt.Errorf("Ollama host missing")
+	}
+	params := cfg.Models["ollama"]
+	if params.Model == "" {
+		return nil, fmt.Errorf("Ollama model missing")
+	}
+	host := strings.TrimRight(cfg.OllamaHost, "/")
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	return &OllamaReviewer{Host: host, Params: params, client: clientFor("ollama", cfg)}, nil
+}
+
+func (o *OllamaReviewer) Name() string { return "ollama" }
+
+// Review sends diff + title to the local model.
+func (o *OllamaReviewer) Review(ctx context.Context, req ReviewRequest) (*Review, error) {
+	reqBody := OllamaRequest{
+		Model: o.Params.Model,
+		Messages: []OpenAIMessage{
+			{Role: "system", Content: systemPrompt(req, o.Params)},
+			{Role: "user", Content: reviewPrompt(req)},
+		},
+		Format: "json",
+		Options: &OllamaOptions{
+			Temperature: o.Params.Temperature,
+			TopP:        o.Params.TopP,
+			NumPredict:  o.Params.MaxTokens,
+			Stop:        o.Params.StopSequences,
+		},
+	}
+	jsonData, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", o.Host+"/api/chat", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %v", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := o.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call Ollama at %s: %w", o.Host, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %v", err)
+	}
+
+	var out OllamaResponse
+	if err := json.Unmarshal(body, &out); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &APIError{Provider: "Ollama", StatusCode: resp.StatusCode, Message: out.Error}
+	}
+	text := strings.TrimSpace(out.Message.Content)
+	if text == "" {
+		return nil, fmt.Errorf("no response from Ollama")
+	}
+
+	review := &Review{
+		Text:     text,
+		Provider: o.Name(),
+		Model:    o.Params.Model,
+		Usage:    Usage{PromptTokens: out.PromptEvalCount, CompletionTokens: out.EvalCount},
+	}
+	if out.DoneReason == "length" {
+		review.Truncated, review.StopReason = true, StopMaxTokens
+	}
+	return review, nil
+}
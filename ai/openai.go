@@ -0,0 +1,320 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"codesage/config"
+)
+
+const defaultOpenAIBaseURL = "https://api.openai.com/v1"
+
+// OpenAIMessage is a single chat message. Assistant messages may carry
+// tool calls, which are answered by "tool" messages with the call's ID.
+type OpenAIMessage struct {
+	Role       string           `json:"role"`
+	Content    string           `json:"content"`
+	ToolCalls  []OpenAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+// OpenAIToolCall is a function call requested by the model.
+type OpenAIToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// OpenAITool declares a function the model may call.
+type OpenAITool struct {
+	Type     string             `json:"type"`
+	Function OpenAIFunctionDecl `json:"function"`
+}
+
+// OpenAIFunctionDecl describes a function and its JSON schema parameters.
+type OpenAIFunctionDecl struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Parameters  map[string]any `json:"parameters,omitempty"`
+}
+
+// OpenAIRequest is the chat completions request payload.
+type OpenAIRequest struct {
+	Model          string                `json:"model"`
+	Messages       []OpenAIMessage       `json:"messages"`
+	Temperature    *float64              `json:"temperature,omitempty"`
+	TopP           *float64              `json:"top_p,omitempty"`
+	MaxTokens      int                   `json:"max_tokens,omitempty"`
+	Stop           []string              `json:"stop,omitempty"`
+	ResponseFormat *OpenAIResponseFormat `json:"response_format,omitempty"`
+	Stream         bool                  `json:"stream,omitempty"`
+	StreamOptions  *OpenAIStreamOptions  `json:"stream_options,omitempty"`
+	Tools          []OpenAITool          `json:"tools,omitempty"`
+	ToolChoice     string                `json:"tool_choice,omitempty"`
+}
+
+// OpenAIStreamOptions asks for token usage in the final stream event.
+type OpenAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// OpenAIResponseFormat asks the server for a specific output format.
+type OpenAIResponseFormat struct {
+	Type string `json:"type"`
+}
+
+// OpenAIChoice is one completion. Streamed events carry Delta instead of
+// Message.
+type OpenAIChoice struct {
+	Message      OpenAIMessage `json:"message"`
+	Delta        OpenAIMessage `json:"delta"`
+	FinishReason string        `json:"finish_reason"`
+}
+
+// OpenAIResponse is the chat completions response payload, and the shape of
+// every event in a streamed response.
+type OpenAIResponse struct {
+	Model   string         `json:"model"`
+	Choices []OpenAIChoice `json:"choices"`
+	Usage   struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// OpenAIReviewer talks to any OpenAI-compatible chat completions endpoint:
+// OpenAI itself, Azure OpenAI, vLLM, llama.cpp or LM Studio.
+type OpenAIReviewer struct {
+	APIKey  string
+	BaseURL string
+	Params  config.ModelParams
+	client  *apiClient
+}
+
+func init() {
+	Register("openai", NewOpenAIReviewer)
+}
+
+// NewOpenAIReviewer builds an OpenAI-compatible provider. The API key is
+// only required for the default OpenAI endpoint; self-hosted servers often
+// run without one.
+func NewOpenAIReviewer(cfg *config.Config) (Reviewer, error) {
+	baseURL := strings.TrimRight(cfg.OpenAIBaseURL, "/")
+	if baseURL == "" {
+		baseURL = defaultOpenAIBaseURL
+	}
+	if cfg.OpenAIKey == "" && baseURL == defaultOpenAIBaseURL {
+		return nil, fmt.Errorf("OpenAI API key missing")
+	}
+	params := cfg.Models["openai"]
+	if params.Model == "" {
+		return nil, fmt.Errorf("OpenAI model missing")
+	}
+	return &OpenAIReviewer{APIKey: cfg.OpenAIKey, BaseURL: baseURL, Params: params, client: clientFor("openai", cfg)}, nil
+}
+
+func (o *OpenAIReviewer) Name() string { return "openai" }
+
+// Review sends diff + title to the chat completions endpoint. When the
+// request offers tools, the model may call them before answering.
+func (o *OpenAIReviewer) Review(ctx context.Context, req ReviewRequest) (*Review, error) {
+	reqBody := OpenAIRequest{
+		Model: o.Params.Model,
+		Messages: []OpenAIMessage{
+			{Role: "system", Content: systemPrompt(req, o.Params)},
+			{Role: "user", Content: reviewPrompt(req)},
+		},
+		Temperature:    o.Params.Temperature,
+		TopP:           o.Params.TopP,
+		MaxTokens:      o.Params.MaxTokens,
+		Stop:           o.Params.StopSequences,
+		ResponseFormat: &OpenAIResponseFormat{Type: "json_object"},
+	}
+	session := newToolSession(req)
+	onProgress := req.OnProgress
+	if session != nil {
+		reqBody.Tools = openAITools(session)
+		// Only the final answer is worth streaming, and which turn gives it
+		// is only known afterwards.
+		onProgress = nil
+	}
+
+	var out OpenAIResponse
+	var usage Usage
+	for {
+		var err error
+		if out, err = o.complete(ctx, reqBody, onProgress); err != nil {
+			return nil, err
+		}
+		usage.Add(Usage{PromptTokens: out.Usage.PromptTokens, CompletionTokens: out.Usage.CompletionTokens})
+		if len(out.Choices) == 0 {
+			return nil, fmt.Errorf("no response from OpenAI")
+		}
+		msg := out.Choices[0].Message
+		if session == nil || len(msg.ToolCalls) == 0 {
+			break
+		}
+		if session.exhausted() {
+			return nil, fmt.Errorf("OpenAI kept calling tools after the budget of %d steps was spent", session.maxSteps)
+		}
+		reqBody.Messages = append(reqBody.Messages, msg)
+		for _, call := range msg.ToolCalls {
+			result := session.run(ctx, ToolCall{ID: call.ID, Name: call.Function.Name, Args: json.RawMessage(call.Function.Arguments)})
+			reqBody.Messages = append(reqBody.Messages, OpenAIMessage{Role: "tool", Content: result, ToolCallID: call.ID})
+		}
+		if session.exhausted() {
+			reqBody.Messages = append(reqBody.Messages, OpenAIMessage{Role: "user", Content: finalNudge})
+			reqBody.ToolChoice = "none"
+		}
+	}
+
+	choice := out.Choices[0]
+	text := strings.TrimSpace(choice.Message.Content)
+	if text == "" {
+		if choice.FinishReason == "content_filter" {
+			return nil, &BlockedError{Provider: "OpenAI", Stage: "response", Reason: "content_filter"}
+		}
+		empty := &EmptyResponseError{Provider: "OpenAI", FinishReason: choice.FinishReason}
+		if choice.FinishReason == "length" {
+			empty.StopReason = StopMaxTokens
+		}
+		return nil, empty
+	}
+
+	review := &Review{
+		Text:     text,
+		Provider: o.Name(),
+		Model:    o.Params.Model,
+		Usage:    usage,
+	}
+	switch choice.FinishReason {
+	case "length":
+		review.Truncated, review.StopReason = true, StopMaxTokens
+	case "content_filter":
+		review.Truncated, review.StopReason = true, StopSafety
+	}
+	return review, nil
+}
+
+// complete makes one chat completions call, streaming when onProgress is
+// set.
+func (o *OpenAIReviewer) complete(ctx context.Context, reqBody OpenAIRequest, onProgress func(Progress)) (OpenAIResponse, error) {
+	var out OpenAIResponse
+	stream := onProgress != nil
+	if stream {
+		reqBody.Stream = true
+		reqBody.StreamOptions = &OpenAIStreamOptions{IncludeUsage: true}
+	}
+	jsonData, err := json.Marshal(reqBody)
+	if err != nil {
+		return out, fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", o.BaseURL+"/chat/completions", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return out, fmt.Errorf("failed to build request: %v", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	o.setAuth(httpReq)
+
+	var resp *http.Response
+	if stream {
+		resp, err = o.client.Stream(httpReq)
+	} else {
+		resp, err = o.client.Do(httpReq)
+	}
+	if err != nil {
+		return out, fmt.Errorf("failed to call OpenAI API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if stream && resp.StatusCode == http.StatusOK {
+		return readOpenAIStream(resp.Body, onProgress)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return out, fmt.Errorf("failed to read response: %v", err)
+	}
+	if err := json.Unmarshal(body, &out); err != nil && resp.StatusCode == http.StatusOK {
+		return out, fmt.Errorf("failed to decode response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &APIError{Provider: "OpenAI", StatusCode: resp.StatusCode}
+		if out.Error != nil {
+			apiErr.Message = out.Error.Message
+		}
+		return out, apiErr
+	}
+	return out, nil
+}
+
+// openAITools declares the session's tools as functions.
+func openAITools(s *toolSession) []OpenAITool {
+	tools := make([]OpenAITool, len(s.order))
+	for i, t := range s.order {
+		tools[i] = OpenAITool{Type: "function", Function: OpenAIFunctionDecl{Name: t.Name, Description: t.Description, Parameters: t.Parameters}}
+	}
+	return tools
+}
+
+// readOpenAIStream folds a streamed chat completion into the shape of a
+// regular response, reporting the text so far after every delta. Usage
+// arrives in a final event without choices.
+func readOpenAIStream(body io.Reader, onProgress func(Progress)) (OpenAIResponse, error) {
+	var out OpenAIResponse
+	var text strings.Builder
+	finish := ""
+	err := readSSE(body, func(data []byte) error {
+		var event OpenAIResponse
+		if err := json.Unmarshal(data, &event); err != nil {
+			return fmt.Errorf("failed to decode stream event: %v", err)
+		}
+		if event.Error != nil {
+			return fmt.Errorf("OpenAI stream failed: %s", event.Error.Message)
+		}
+		if event.Model != "" {
+			out.Model = event.Model
+		}
+		if event.Usage.PromptTokens > 0 || event.Usage.CompletionTokens > 0 {
+			out.Usage = event.Usage
+		}
+		if len(event.Choices) == 0 {
+			return nil
+		}
+		if event.Choices[0].FinishReason != "" {
+			finish = event.Choices[0].FinishReason
+		}
+		if delta := event.Choices[0].Delta.Content; delta != "" {
+			text.WriteString(delta)
+			onProgress(Progress{Text: text.String()})
+		}
+		return nil
+	})
+	out.Choices = []OpenAIChoice{{Message: OpenAIMessage{Role: "assistant", Content: text.String()}, FinishReason: finish}}
+	return out, err
+}
+
+// setAuth adds credentials in the form the target server expects. Azure
+// OpenAI uses an api-key header, everything else a bearer token.
+func (o *OpenAIReviewer) setAuth(req *http.Request) {
+	if o.APIKey == "" {
+		return
+	}
+	if u, err := url.Parse(o.BaseURL); err == nil && strings.HasSuffix(u.Hostname(), ".azure.com") {
+		req.Header.Set("api-key", o.APIKey)
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+o.APIKey)
+}
@@ -0,0 +1,261 @@
+package ai
+
+import (
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+
+	"codesage/config"
+	"codesage/utils"
+)
+
+// findingsInstructions tells the model to answer with a Report as JSON.
+// Templates include it as {{.Schema}} so the wording stays in step with
+// ParseReport no matter how the rest of the prompt is customised.
+const findingsInstructions = `Respond with ONLY a JSON object, without markdown fences or any text around it, in this shape:
+{
+  "summary": "2-3 sentences on what the change does and its overall quality",
+  "findings": [
+    {
+      "file": "path/of/the/file.go",
+      "start_line": 42,
+      "end_line": 45,
+      "severity": "info | minor | major | critical",
+      "category": "bug | security | performance | maintainability | style | testing | documentation",
+      "message": "what needs attention and why",
+      "suggested_fix": "optional replacement code or concrete fix"
+    }
+  ]
+}
+Line numbers refer to the new version of the file. Report at most 10 findings, most important first, and use an empty findings array when nothing needs attention.`
+
+//go:embed prompts/*.tmpl
+var builtinPromptFS embed.FS
+
+// promptNames are the templates a PromptSet is made of. Each is read from
+// "<name>.tmpl" in the override directory, or from the built-in defaults.
+var promptNames = []string{"system", "review", "synthesis"}
+
+// versionPattern matches the version tag templates start with, e.g.
+// {{/* version: review-2 */}}.
+var versionPattern = regexp.MustCompile(`^\s*\{\{-?\s*/\*\s*version:\s*(\S+)\s*\*/`)
+
+var promptFuncs = template.FuncMap{"join": strings.Join}
+
+// PromptData is what prompt templates are rendered with.
+type PromptData struct {
+	Title string
+	// Diff is the rendered diff under review; empty when merging partials.
+	Diff  string
+	Files []FileDiff
+	// Partials is the JSON of the chunk reports being merged, and PartCount
+	// how many there are; both are only set for the synthesis template.
+	Partials  string
+	PartCount int
+	// Schema describes the JSON answer the response parser expects.
+	Schema string
+	// Tools names the tools the model may call, if any.
+	Tools []string
+}
+
+// PromptSet is a loaded, versioned set of prompt templates.
+type PromptSet struct {
+	templates map[string]*template.Template
+	versions  map[string]string
+}
+
+// Version identifies the exact wording of every template in the set, e.g.
+// "system-1+review-1+synthesis-1". It is stored with each review and is
+// part of the cache key.
+func (p *PromptSet) Version() string {
+	parts := make([]string, len(promptNames))
+	for i, name := range promptNames {
+		parts[i] = p.versions[name]
+	}
+	return strings.Join(parts, "+")
+}
+
+// withSystemInstructions returns p versioned for providers configured with
+// instructions, which replace the system template wherever they are set.
+// Each provider contributes its instruction, or "" when it uses the
+// template, so the version changes whenever any of them does.
+func (p *PromptSet) withSystemInstructions(instructions []string) *PromptSet {
+	if strings.Join(instructions, "") == "" {
+		return p
+	}
+	sum := sha256.Sum256([]byte(strings.Join(instructions, "\x00")))
+	versions := make(map[string]string, len(p.versions))
+	for name, v := range p.versions {
+		versions[name] = v
+	}
+	versions["system"] += "+instruction-" + hex.EncodeToString(sum[:])[:8]
+	return &PromptSet{templates: p.templates, versions: versions}
+}
+
+func (p *PromptSet) render(name string, data PromptData) (string, error) {
+	var b strings.Builder
+	if err := p.templates[name].Execute(&b, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// LoadPrompts builds a PromptSet from the built-in templates, replacing any
+// that dir provides. A template's version comes from its version tag; files
+// without one are versioned by a hash of their content.
+func LoadPrompts(dir string) (*PromptSet, error) {
+	p := &PromptSet{templates: map[string]*template.Template{}, versions: map[string]string{}}
+	for _, name := range promptNames {
+		file := name + ".tmpl"
+		data, err := fs.ReadFile(builtinPromptFS, "prompts/"+file)
+		if err != nil {
+			return nil, err
+		}
+		if dir != "" {
+			override, err := os.ReadFile(filepath.Join(dir, file))
+			switch {
+			case err == nil:
+				data = override
+			case !errors.Is(err, os.ErrNotExist):
+				return nil, fmt.Errorf("failed to read prompt template: %v", err)
+			}
+		}
+		tmpl, err := template.New(file).Option("missingkey=error").Funcs(promptFuncs).Parse(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("invalid prompt template %s: %v", file, err)
+		}
+		p.templates[name] = tmpl
+		if m := versionPattern.FindSubmatch(data); m != nil {
+			p.versions[name] = string(m[1])
+		} else {
+			sum := sha256.Sum256(data)
+			p.versions[name] = name + "-" + hex.EncodeToString(sum[:])[:8]
+		}
+	}
+	// Catch references to fields that do not exist before the first review.
+	sample := PromptData{Title: "title", Diff: "diff", Partials: "[]", PartCount: 2, Schema: findingsInstructions, Tools: []string{"tool"}}
+	for _, name := range promptNames {
+		if _, err := p.render(name, sample); err != nil {
+			return nil, fmt.Errorf("prompt template %s.tmpl: %v", name, err)
+		}
+	}
+	return p, nil
+}
+
+var builtinPrompts = func() *PromptSet {
+	p, err := LoadPrompts("")
+	if err != nil {
+		panic("ai: built-in prompts: " + err.Error())
+	}
+	return p
+}()
+
+var promptSets sync.Map // override dir -> *PromptSet
+
+// PromptsFor returns the prompt set for an override directory, loading it
+// on first use. Templates are read once per process; restart to pick up
+// edits.
+func PromptsFor(dir string) (*PromptSet, error) {
+	if dir == "" {
+		return builtinPrompts, nil
+	}
+	if p, ok := promptSets.Load(dir); ok {
+		return p.(*PromptSet), nil
+	}
+	p, err := LoadPrompts(dir)
+	if err != nil {
+		return nil, err
+	}
+	actual, _ := promptSets.LoadOrStore(dir, p)
+	return actual.(*PromptSet), nil
+}
+
+func promptsOf(req ReviewRequest) *PromptSet {
+	if req.Prompts != nil {
+		return req.Prompts
+	}
+	return builtinPrompts
+}
+
+// renderPrompt renders name from the request's prompt set, falling back to
+// the built-in template if the custom one fails on this input.
+func renderPrompt(req ReviewRequest, name string, data PromptData) string {
+	p := promptsOf(req)
+	text, err := p.render(name, data)
+	if err != nil && p != builtinPrompts {
+		utils.Errorf("prompt template %s.tmpl failed, using the built-in one: %v", name, err)
+		text, err = builtinPrompts.render(name, data)
+	}
+	if err != nil {
+		utils.Errorf("prompt template %s.tmpl failed: %v", name, err)
+	}
+	return text
+}
+
+// reviewPrompt builds the user message sent to every provider. The diff is
+// expected to fit the provider's budget already; see Chunked.
+func reviewPrompt(req ReviewRequest) string {
+	data := PromptData{Title: req.Title, Diff: req.Diff, Files: req.Files, Schema: findingsInstructions}
+	if req.MaxToolSteps > 0 {
+		for _, t := range req.Tools {
+			data.Tools = append(data.Tools, t.Name)
+		}
+	}
+	var text string
+	if len(req.Partials) > 0 {
+		partials, _ := json.MarshalIndent(req.Partials, "", "  ")
+		data.Diff = ""
+		data.Partials = string(partials)
+		data.PartCount = len(req.Partials)
+		text = renderPrompt(req, "synthesis", data)
+	} else {
+		text = renderPrompt(req, "review", data)
+	}
+	if req.RepairHint != "" {
+		text += fmt.Sprintf("\n\nYour previous answer could not be used: %s\nAnswer again with valid JSON only.", req.RepairHint)
+	}
+	return text
+}
+
+// systemPrompt returns the configured system instruction or the rendered
+// system template.
+func systemPrompt(req ReviewRequest, p config.ModelParams) string {
+	if p.SystemInstruction != "" {
+		return p.SystemInstruction
+	}
+	return renderPrompt(req, "system", PromptData{Title: req.Title, Schema: findingsInstructions})
+}
+
+// prompted attaches a PromptSet to every request and records its version
+// on the review.
+type prompted struct {
+	Reviewer
+	prompts *PromptSet
+}
+
+func (p *prompted) Review(ctx context.Context, req ReviewRequest) (*Review, error) {
+	if req.Prompts == nil {
+		req.Prompts = p.prompts
+	}
+	// Start the tool budget here so the whole review shares it.
+	req = withToolBudget(req)
+	review, err := p.Reviewer.Review(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if review.PromptVersion == "" {
+		review.PromptVersion = req.Prompts.Version()
+	}
+	return review, nil
+}
@@ -0,0 +1,21 @@
+package ai
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPromptVersionFollowsSystemInstruction(t *testing.T) {
+	base := builtinPrompts.Version()
+	if got := builtinPrompts.withSystemInstructions([]string{"", ""}); got.Version() != base {
+		t.Errorf("version without instructions = %q, want %q", got.Version(), base)
+	}
+	a := builtinPrompts.withSystemInstructions([]string{"Be terse."})
+	b := builtinPrompts.withSystemInstructions([]string{"Be thorough."})
+	if a.Version() == base || a.Version() == b.Version() {
+		t.Errorf("versions %q and %q do not tell the instructions apart from %q", a.Version(), b.Version(), base)
+	}
+	if !strings.Contains(a.Version(), "+instruction-") || builtinPrompts.Version() != base {
+		t.Errorf("version = %q, built-in set changed to %q", a.Version(), builtinPrompts.Version())
+	}
+}
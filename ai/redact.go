@@ -0,0 +1,307 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"codesage/config"
+	"codesage/utils"
+)
+
+// redactRule finds one kind of sensitive value. When the pattern has a
+// group named "value" only that group is replaced, so the surrounding code
+// (e.g. `password = "..."`) stays readable for the model.
+type redactRule struct {
+	kind    string
+	pattern *regexp.Regexp
+}
+
+// builtinRedactRules run in order; connection strings come before emails
+// because "user:pass@host" would otherwise be read as an address.
+var builtinRedactRules = []redactRule{
+	{"private_key", regexp.MustCompile(`-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----(?s:.*?)-----END [A-Z0-9 ]*PRIVATE KEY-----`)},
+	{"jwt", regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`)},
+	{"api_key", regexp.MustCompile(`\b(?:AKIA[0-9A-Z]{16}|AIza[0-9A-Za-z_-]{35}|gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{40,}|sk-(?:ant-|proj-)?[A-Za-z0-9_-]{20,}|xox[abprs]-[A-Za-z0-9-]{10,}|[rs]k_live_[A-Za-z0-9]{20,}|hf_[A-Za-z0-9]{30,})`)},
+	{"connection_string", regexp.MustCompile(`\b[a-zA-Z][a-zA-Z0-9+.-]*://[^\s:/@"'<>]+:[^\s@/"'<>]+@[^\s"'<>]+`)},
+	{"secret", regexp.MustCompile(`(?i)(?:api[_-]?key|secret|token|passw(?:or)?d|pwd|credentials?)["']?\s*[:=]\s*["'](?P<value>[^"'\s]{8,})["']`)},
+	{"email", regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}\b`)},
+}
+
+// Redactor replaces secrets and personal data in diffs with placeholders
+// before they are sent to a provider.
+type Redactor struct {
+	rules []redactRule
+	// restore lists the kinds put back into the review. Secrets are never
+	// restored: the comment would publish them a second time.
+	restore map[string]bool
+}
+
+// NewRedactor builds a Redactor from the built-in rules plus custom
+// patterns keyed by kind. Custom kinds are treated as secrets unless listed
+// in restore.
+func NewRedactor(custom map[string]string, restore []string) (*Redactor, error) {
+	r := &Redactor{rules: append([]redactRule(nil), builtinRedactRules...), restore: map[string]bool{}}
+	kinds := make([]string, 0, len(custom))
+	for kind := range custom {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		re, err := regexp.Compile(custom[kind])
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %v", kind, err)
+		}
+		r.rules = append(r.rules, redactRule{kind: strings.ToLower(kind), pattern: re})
+	}
+	for _, kind := range restore {
+		r.restore[strings.ToLower(kind)] = true
+	}
+	return r, nil
+}
+
+// Find reports the kinds of sensitive value in text without changing it.
+func (r *Redactor) Find(text string) []string {
+	var kinds []string
+	for _, rule := range r.rules {
+		if rule.pattern.MatchString(text) {
+			kinds = append(kinds, rule.kind)
+		}
+	}
+	return kinds
+}
+
+// Redaction remembers the placeholders issued for one review so the same
+// value always gets the same placeholder and restorable ones can be undone.
+type Redaction struct {
+	r            *Redactor
+	placeholders map[string]string // value -> placeholder
+	counts       map[string]int    // kind -> distinct values
+
+	mu       sync.Mutex
+	restorer *strings.Replacer
+}
+
+// Begin starts a redaction for one review.
+func (r *Redactor) Begin() *Redaction {
+	return &Redaction{r: r, placeholders: map[string]string{}, counts: map[string]int{}}
+}
+
+// Apply returns text with every sensitive value replaced. Placeholders are
+// numbered in order of appearance, so the same diff always redacts to the
+// same text. Multi-line values keep their line breaks and diff markers so
+// line numbers stay valid.
+func (x *Redaction) Apply(text string) string {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for _, rule := range x.r.rules {
+		group := rule.pattern.SubexpIndex("value")
+		text = replaceAllSubmatchFunc(rule.pattern, text, group, func(value string) string {
+			return x.placeholder(rule.kind, value)
+		})
+	}
+	return text
+}
+
+func (x *Redaction) placeholder(kind, value string) string {
+	if strings.HasPrefix(value, "[REDACTED_") {
+		return value
+	}
+	p, ok := x.placeholders[value]
+	if !ok {
+		x.counts[kind]++
+		p = fmt.Sprintf("[REDACTED_%s_%d]", strings.ToUpper(kind), x.counts[kind])
+		x.placeholders[value] = p
+		x.restorer = nil
+	}
+	// Keep the line structure of values that span several diff lines.
+	lines := strings.Split(value, "\n")
+	for i := 1; i < len(lines); i++ {
+		if lines[i] != "" && strings.ContainsRune("+- ", rune(lines[i][0])) {
+			lines[i] = lines[i][:1]
+		} else {
+			lines[i] = ""
+		}
+	}
+	lines[0] = p
+	return strings.Join(lines, "\n")
+}
+
+// replaceAllSubmatchFunc is ReplaceAllStringFunc that, when group >= 0,
+// replaces only that submatch.
+func replaceAllSubmatchFunc(re *regexp.Regexp, text string, group int, fn func(string) string) string {
+	if group < 0 {
+		return re.ReplaceAllStringFunc(text, fn)
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range re.FindAllStringSubmatchIndex(text, -1) {
+		start, end := m[2*group], m[2*group+1]
+		if start < 0 {
+			continue
+		}
+		b.WriteString(text[last:start])
+		b.WriteString(fn(text[start:end]))
+		last = end
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// Counts reports how many distinct values of each kind were redacted.
+func (x *Redaction) Counts() map[string]int {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	counts := make(map[string]int, len(x.counts))
+	for kind, n := range x.counts {
+		counts[kind] = n
+	}
+	return counts
+}
+
+// Total is the number of distinct values redacted.
+func (x *Redaction) Total() int {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	n := 0
+	for _, c := range x.counts {
+		n += c
+	}
+	return n
+}
+
+// Restore puts restorable values back into model output. Models sometimes
+// drop the brackets, so bare placeholders are recognised too.
+func (x *Redaction) Restore(text string) string {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if len(x.placeholders) == 0 {
+		return text
+	}
+	if x.restorer == nil {
+		var pairs [][2]string
+		for value, p := range x.placeholders {
+			kind := strings.ToLower(p[len("[REDACTED_"):strings.LastIndex(p, "_")])
+			if x.r.restore[kind] {
+				pairs = append(pairs, [2]string{p, value}, [2]string{strings.Trim(p, "[]"), value})
+			}
+		}
+		// Longest first so _12 is not read as _1 followed by "2".
+		sort.Slice(pairs, func(i, j int) bool { return len(pairs[i][0]) > len(pairs[j][0]) })
+		var args []string
+		for _, pair := range pairs {
+			args = append(args, pair[0], pair[1])
+		}
+		x.restorer = strings.NewReplacer(args...)
+	}
+	return x.restorer.Replace(text)
+}
+
+// summary formats the counts for the audit log, e.g. "api_key=1, email=2".
+func (x *Redaction) summary() string {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	kinds := make([]string, 0, len(x.counts))
+	for kind, n := range x.counts {
+		kinds = append(kinds, fmt.Sprintf("%s=%d", kind, n))
+	}
+	sort.Strings(kinds)
+	return strings.Join(kinds, ", ")
+}
+
+var (
+	redactorOnce sync.Once
+	redactor     *Redactor
+)
+
+// redactorFor returns the process-wide redactor configured by cfg, or nil
+// when redaction is off.
+func redactorFor(cfg *config.Config) *Redactor {
+	redactorOnce.Do(func() {
+		if !cfg.AIRedact {
+			return
+		}
+		var err error
+		redactor, err = NewRedactor(cfg.AIRedactPatterns, cfg.AIRedactRestore)
+		if err != nil {
+			utils.Errorf("%v; using the built-in redaction rules only", err)
+			redactor, _ = NewRedactor(nil, cfg.AIRedactRestore)
+		}
+	})
+	return redactor
+}
+
+// Redacted strips secrets and personal data from a request before any
+// provider sees it, and restores the restorable kinds in the review.
+type Redacted struct {
+	Reviewer
+	Redactor *Redactor
+}
+
+func (r *Redacted) Review(ctx context.Context, req ReviewRequest) (*Review, error) {
+	x := r.Redactor.Begin()
+	files := make([]FileDiff, len(req.Files))
+	for i, f := range req.Files {
+		files[i] = FileDiff{Path: f.Path, Patch: x.Apply(f.Patch), Context: x.Apply(f.Context)}
+	}
+	req.Files = files
+	req.Diff = x.Apply(req.Diff)
+	req.Title = x.Apply(req.Title)
+	if onProgress := req.OnProgress; onProgress != nil {
+		req.OnProgress = func(p Progress) {
+			p.Text = x.Restore(p.Text)
+			onProgress(p)
+		}
+	}
+	// Tools read the repository, which can hold the same secrets as the
+	// diff; their results share its placeholders.
+	if len(req.Tools) > 0 {
+		tools := make([]Tool, len(req.Tools))
+		for i, t := range req.Tools {
+			call := t.Call
+			t.Call = func(ctx context.Context, args json.RawMessage) (string, error) {
+				out, err := call(ctx, args)
+				return x.Apply(out), err
+			}
+			tools[i] = t
+		}
+		req.Tools = tools
+	}
+
+	attr := attributionFrom(ctx)
+	before := x.Counts()
+	if total := x.Total(); total > 0 {
+		utils.Infof("redacted %d sensitive value(s) from %s#%d before review: %s", total, attr.Repo, attr.PR, x.summary())
+		for kind, n := range before {
+			metrics.Add("redactions."+kind, int64(n))
+		}
+	}
+
+	review, err := r.Reviewer.Review(ctx, req)
+	// Count what was redacted from tool results, even if the review failed.
+	for kind, n := range x.Counts() {
+		if n > before[kind] {
+			utils.Infof("redacted %d sensitive value(s) of kind %s from tool results for %s#%d", n-before[kind], kind, attr.Repo, attr.PR)
+			metrics.Add("redactions."+kind, int64(n-before[kind]))
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	out := *review
+	out.Text = x.Restore(review.Text)
+	out.Summary = x.Restore(review.Summary)
+	out.Findings = make([]Finding, len(review.Findings))
+	for i, f := range review.Findings {
+		f.File = x.Restore(f.File)
+		f.Message = x.Restore(f.Message)
+		f.SuggestedFix = x.Restore(f.SuggestedFix)
+		out.Findings[i] = f
+	}
+	out.Redactions = x.Total()
+	return &out, nil
+}
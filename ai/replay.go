@@ -0,0 +1,155 @@
+package ai
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Replay modes for ReplayTransport.
+const (
+	ModeRecord = "record"
+	ModeReplay = "replay"
+)
+
+// Fixture is one recorded HTTP exchange. Credentials are never written:
+// request headers are not stored and the "key" query parameter is removed.
+type Fixture struct {
+	Request struct {
+		Method string `json:"method"`
+		URL    string `json:"url"`
+		Body   string `json:"body"`
+	} `json:"request"`
+	Response struct {
+		StatusCode int               `json:"status_code"`
+		Header     map[string]string `json:"header"`
+		Body       string            `json:"body"`
+	} `json:"response"`
+}
+
+// ReplayTransport records real provider exchanges as fixtures and replays
+// them offline. Fixtures are stored as JSON files in Dir, named by a hash
+// of the method, credential-free URL and request body, so the same review
+// request always maps to the same file.
+type ReplayTransport struct {
+	Mode string
+	Dir  string
+	// Base performs real requests in record mode; http.DefaultTransport if nil.
+	Base http.RoundTripper
+}
+
+// NewReplayTransport builds a transport for mode "record" or "replay".
+func NewReplayTransport(mode, dir string) (*ReplayTransport, error) {
+	if mode != ModeRecord && mode != ModeReplay {
+		return nil, fmt.Errorf("unknown replay mode %q", mode)
+	}
+	return &ReplayTransport{Mode: mode, Dir: dir}, nil
+}
+
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	cleanURL := redactURL(req.URL)
+	path := filepath.Join(t.Dir, fixtureName(req.Method, cleanURL, body))
+
+	if t.Mode == ModeReplay {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("no recorded fixture for %s %s (%s): %v", req.Method, cleanURL, filepath.Base(path), err)
+		}
+		var fx Fixture
+		if err := json.Unmarshal(data, &fx); err != nil {
+			return nil, fmt.Errorf("corrupt fixture %s: %v", path, err)
+		}
+		resp := &http.Response{
+			StatusCode:    fx.Response.StatusCode,
+			Status:        fmt.Sprintf("%d %s", fx.Response.StatusCode, http.StatusText(fx.Response.StatusCode)),
+			Header:        http.Header{},
+			Body:          io.NopCloser(strings.NewReader(fx.Response.Body)),
+			ContentLength: int64(len(fx.Response.Body)),
+			Request:       req,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+		}
+		for k, v := range fx.Response.Header {
+			resp.Header.Set(k, v)
+		}
+		return resp, nil
+	}
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	var fx Fixture
+	fx.Request.Method = req.Method
+	fx.Request.URL = cleanURL
+	fx.Request.Body = string(body)
+	fx.Response.StatusCode = resp.StatusCode
+	fx.Response.Header = map[string]string{}
+	for _, k := range []string{"Content-Type", "Retry-After"} {
+		if v := resp.Header.Get(k); v != "" {
+			fx.Response.Header[k] = v
+		}
+	}
+	fx.Response.Body = string(respBody)
+	data, err := json.MarshalIndent(fx, "", "  ")
+	if err == nil {
+		if err = os.MkdirAll(t.Dir, 0o755); err == nil {
+			err = os.WriteFile(path, data, 0o644)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to record fixture %s: %v", path, err)
+	}
+	return resp, nil
+}
+
+// redactURL drops credentials passed in the query string.
+func redactURL(u *url.URL) string {
+	clean := *u
+	q := clean.Query()
+	q.Del("key")
+	clean.RawQuery = q.Encode()
+	clean.User = nil
+	return clean.String()
+}
+
+func fixtureName(method, cleanURL string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method + " " + cleanURL + "\n"))
+	h.Write(body)
+	host := "fixture"
+	if u, err := url.Parse(cleanURL); err == nil && u.Host != "" {
+		host = strings.ReplaceAll(u.Hostname(), ".", "_")
+	}
+	return host + "-" + hex.EncodeToString(h.Sum(nil))[:16] + ".json"
+}
@@ -0,0 +1,196 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"codesage/config"
+)
+
+// ReviewRequest carries everything a provider needs to review a pull request.
+type ReviewRequest struct {
+	Title string
+	Diff  string
+	// Files holds the per-file patches Diff was rendered from. Chunked uses
+	// it to split large PRs along file and hunk boundaries.
+	Files []FileDiff
+	// Partials, when set, asks the provider to merge chunk reports instead
+	// of reviewing a diff.
+	Partials []Report
+	// Fresh skips the review cache.
+	Fresh bool
+	// RepairHint explains why the previous answer was rejected, if any.
+	RepairHint string
+	// Prompts renders the prompts; the built-in templates when nil.
+	Prompts *PromptSet
+	// OnProgress, when set, asks providers that support it to stream and
+	// is called as output arrives. It may be called from several
+	// goroutines at once.
+	OnProgress func(Progress)
+	// Tools, when set, lets providers that support function calling look
+	// beyond the diff, for at most MaxToolSteps calls per review. The
+	// budget covers every chunk, repair retry and fallback provider.
+	Tools        []Tool
+	MaxToolSteps int
+	// toolSteps counts the review's tool calls; see withToolBudget.
+	toolSteps *atomic.Int64
+}
+
+// Usage reports the tokens a provider call consumed and what it cost.
+type Usage struct {
+	PromptTokens     int
+	CompletionTokens int
+	CostUSD          float64
+}
+
+// Add accumulates o into u.
+func (u *Usage) Add(o Usage) {
+	u.PromptTokens += o.PromptTokens
+	u.CompletionTokens += o.CompletionTokens
+	u.CostUSD += o.CostUSD
+}
+
+// Review is the result returned by a provider. Text holds the raw model
+// output until Structured parses it, after which it is the rendered report.
+type Review struct {
+	Text     string
+	Provider string
+	Model    string
+	Summary  string
+	Findings []Finding
+	Usage    Usage
+	// Truncated is set when the model stopped before finishing its answer;
+	// StopReason says why.
+	Truncated  bool
+	StopReason StopReason
+	// Partial is set when parts of the diff went unreviewed, or were
+	// reviewed only in part, because their provider calls failed or stopped
+	// early or because the PR had more chunks than AI_MAX_CHUNKS allows.
+	Partial bool
+	// Coverage says, in markdown, which parts of the diff went unreviewed.
+	// It is kept out of Text so each output can place it.
+	Coverage string
+	// FallbackFrom lists providers that were tried or skipped before this one.
+	FallbackFrom []string
+	// Redactions counts the sensitive values hidden from the provider.
+	Redactions int
+	// PromptVersion identifies the prompt templates that produced the review.
+	PromptVersion string
+	// Cached is set when the review was reused from an earlier identical diff.
+	Cached bool `json:"-"`
+}
+
+// StopReason explains why a provider returned a partial review.
+type StopReason string
+
+const (
+	// StopMaxTokens means the model reached its output token limit.
+	StopMaxTokens StopReason = "max_tokens"
+	// StopSafety means the provider's safety filters stopped generation.
+	StopSafety StopReason = "safety"
+	// StopRecitation means generation stopped because the output repeated
+	// training data too closely.
+	StopRecitation StopReason = "recitation"
+)
+
+// Reviewer is implemented by every AI backend that can review a diff.
+type Reviewer interface {
+	Name() string
+	Review(ctx context.Context, req ReviewRequest) (*Review, error)
+}
+
+// Factory builds a Reviewer from the loaded configuration.
+type Factory func(cfg *config.Config) (Reviewer, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Factory{}
+)
+
+// Register makes a provider available under the given name.
+// Providers call it from init so that importing package ai is enough.
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	name = strings.ToLower(name)
+	if _, dup := registry[name]; dup {
+		panic("ai: provider registered twice: " + name)
+	}
+	registry[name] = factory
+}
+
+// Providers returns the names of all registered providers.
+func Providers() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New builds the provider registered under name. Every call it makes is
+// recorded in the usage ledger.
+func New(name string, cfg *config.Config) (Reviewer, error) {
+	name = strings.ToLower(name)
+	registryMu.RLock()
+	factory, ok := registry[name]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown AI provider %q (available: %s)", name, strings.Join(Providers(), ", "))
+	}
+	r, err := factory(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &metered{Reviewer: r, model: cfg.Models[name].Model, ledger: UsageLedger(cfg)}, nil
+}
+
+// FromConfig builds the provider selected by cfg. When cfg.AIProviders lists
+// more than one provider they are wrapped in a fallback Chain.
+// The result is wrapped in Structured so callers always get findings, in
+// Chunked so large diffs are reviewed in parts, in Cached so identical
+// diffs are not reviewed twice, in Redacted so secrets never reach a
+// provider, and finally given the prompt templates from cfg.AIPromptsDir.
+func FromConfig(cfg *config.Config) (Reviewer, error) {
+	prompts, err := PromptsFor(cfg.AIPromptsDir)
+	if err != nil {
+		return nil, err
+	}
+	names := cfg.AIProviders
+	if len(names) == 0 {
+		names = []string{cfg.AIProvider}
+	}
+	instructions := make([]string, len(names))
+	for i, name := range names {
+		instructions[i] = cfg.Models[strings.ToLower(name)].SystemInstruction
+	}
+	prompts = prompts.withSystemInstructions(instructions)
+	var r Reviewer
+	if len(names) > 1 {
+		r, err = NewChain(names, cfg)
+	} else {
+		r, err = New(names[0], cfg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r = NewChunked(&Structured{Reviewer: r, Retries: cfg.AIRepairRetries}, names, cfg)
+	if store := cacheFor(cfg); store != nil {
+		identity := make([]string, len(names))
+		for i, name := range names {
+			identity[i] = strings.ToLower(name) + "@" + cfg.Models[strings.ToLower(name)].Model
+		}
+		r = &Cached{Reviewer: r, Store: store, Identity: strings.Join(identity, ",")}
+	}
+	if redactor := redactorFor(cfg); redactor != nil {
+		r = &Redacted{Reviewer: r, Redactor: redactor}
+	}
+	return &prompted{Reviewer: r, prompts: prompts}, nil
+}
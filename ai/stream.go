@@ -0,0 +1,129 @@
+package ai
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Progress is a snapshot of an in-flight review, passed to
+// ReviewRequest.OnProgress while a streaming provider generates.
+type Progress struct {
+	// Text is the raw model output received so far by the current call.
+	Text string
+	// PartsDone and Parts count the chunk reviews of a large PR; both are
+	// zero when the diff was reviewed in one call.
+	PartsDone int
+	Parts     int
+}
+
+// readSSE calls fn with the data of every server-sent event in r until the
+// stream ends or fn returns an error. "[DONE]" sentinels are skipped.
+func readSSE(r io.Reader, fn func(data []byte) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	var data bytes.Buffer
+	flush := func() error {
+		defer data.Reset()
+		if data.Len() == 0 || bytes.Equal(data.Bytes(), []byte("[DONE]")) {
+			return nil
+		}
+		return fn(data.Bytes())
+	}
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if err := flush(); err != nil {
+				return err
+			}
+			continue
+		}
+		if value, ok := strings.CutPrefix(line, "data:"); ok {
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(value, " "))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read stream: %v", err)
+	}
+	return flush()
+}
+
+// PartialReport extracts what it can from incomplete model output: the
+// summary once its string is closed and every finding whose object is
+// complete. It never fails; unparseable text yields an empty report.
+func PartialReport(text string) *Report {
+	report := &Report{}
+	start := strings.Index(text, "{")
+	if start < 0 {
+		return report
+	}
+	dec := json.NewDecoder(strings.NewReader(text[start:]))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return report
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return report
+		}
+		switch tok {
+		case "summary":
+			var summary string
+			if dec.Decode(&summary) != nil {
+				return report
+			}
+			report.Summary = strings.TrimSpace(summary)
+		case "findings":
+			if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+				return report
+			}
+			for dec.More() {
+				var f Finding
+				if dec.Decode(&f) != nil {
+					return report
+				}
+				if f.normalize() == nil {
+					report.Findings = append(report.Findings, f)
+				}
+			}
+			if _, err := dec.Token(); err != nil {
+				return report
+			}
+		default:
+			var skip json.RawMessage
+			if dec.Decode(&skip) != nil {
+				return report
+			}
+		}
+	}
+	return report
+}
+
+// RenderProgress formats an in-flight review for a placeholder comment.
+func RenderProgress(p Progress) string {
+	var b strings.Builder
+	switch {
+	case p.Parts > 0 && p.PartsDone < p.Parts:
+		fmt.Fprintf(&b, "⏳ Large pull request: reviewed %d of %d parts so far…\n", p.PartsDone, p.Parts)
+	case p.Parts > 0:
+		b.WriteString("⏳ All parts reviewed, writing the combined review…\n")
+	default:
+		b.WriteString("⏳ Review in progress…\n")
+	}
+	report := PartialReport(p.Text)
+	if report.Summary != "" {
+		b.WriteString("\n")
+		b.WriteString(report.Summary)
+		b.WriteString("\n")
+	}
+	if len(report.Findings) > 0 {
+		b.WriteString(RenderFindings("Findings so far", report.Findings))
+	}
+	return strings.TrimSpace(b.String())
+}
@@ -0,0 +1,29 @@
+package ai
+
+import "testing"
+
+func TestPartialReport(t *testing.T) {
+	full := `{"summary": "Two issues.", "findings": [{"file": "a.go", "start_line": 1, "severity": "major", "message": "first"}, {"file": "b.go", "start_line": 2, "severity": "minor", "message": "second"}]}`
+	tests := []struct {
+		name     string
+		text     string
+		summary  string
+		findings int
+	}{
+		{"empty", "", "", 0},
+		{"prose", "Thinking about it", "", 0},
+		{"open summary", `{"summary": "Two is`, "", 0},
+		{"closed summary", `{"summary": "Two issues.", "findings": [`, "Two issues.", 0},
+		{"one finding", full[:len(`{"summary": "Two issues.", "findings": [{"file": "a.go", "start_line": 1, "severity": "major", "message": "first"}, {"file": "b.g`)], "Two issues.", 1},
+		{"complete", full, "Two issues.", 2},
+		{"fenced", "```json\n" + full, "Two issues.", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := PartialReport(tt.text)
+			if report.Summary != tt.summary || len(report.Findings) != tt.findings {
+				t.Errorf("PartialReport = %q with %d findings, want %q with %d", report.Summary, len(report.Findings), tt.summary, tt.findings)
+			}
+		})
+	}
+}
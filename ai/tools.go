@@ -0,0 +1,128 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"codesage/utils"
+)
+
+// toolResultLimit caps what a single tool call returns to the model, so one
+// large file cannot exhaust the context window.
+const toolResultLimit = 12000
+
+// Tool is a function the model may call during an agentic review, e.g. to
+// read a file the diff refers to. Tools are request-scoped because they
+// act on one repository at one revision.
+type Tool struct {
+	Name        string
+	Description string
+	// Parameters is the JSON schema of the arguments object.
+	Parameters map[string]any
+	Call       func(ctx context.Context, args json.RawMessage) (string, error)
+}
+
+// ToolCall is one call the model asked for.
+type ToolCall struct {
+	ID   string
+	Name string
+	Args json.RawMessage
+}
+
+// toolSession runs the tool calls of one provider call. Sessions of the
+// same review share its step budget.
+type toolSession struct {
+	tools    map[string]Tool
+	order    []Tool
+	steps    *atomic.Int64
+	maxSteps int64
+}
+
+// withToolBudget gives req a step counter that every chunk, retry and
+// fallback provider of the review draws from. Requests that already have
+// one keep it.
+func withToolBudget(req ReviewRequest) ReviewRequest {
+	if len(req.Tools) > 0 && req.toolSteps == nil {
+		req.toolSteps = new(atomic.Int64)
+	}
+	return req
+}
+
+// newToolSession returns nil when the request offers no tools, in which
+// case providers review the diff alone.
+func newToolSession(req ReviewRequest) *toolSession {
+	if len(req.Tools) == 0 || req.MaxToolSteps <= 0 {
+		return nil
+	}
+	req = withToolBudget(req)
+	s := &toolSession{tools: map[string]Tool{}, order: req.Tools, steps: req.toolSteps, maxSteps: int64(req.MaxToolSteps)}
+	for _, t := range req.Tools {
+		s.tools[t.Name] = t
+	}
+	return s
+}
+
+// exhausted reports whether the step budget is used up; the provider must
+// then ask for the final answer without tools.
+func (s *toolSession) exhausted() bool { return s.steps.Load() >= s.maxSteps }
+
+// run executes call. Failures are returned to the model as text so it can
+// correct itself instead of aborting the review.
+func (s *toolSession) run(ctx context.Context, call ToolCall) string {
+	step := s.steps.Add(1)
+	if step > s.maxSteps {
+		return "error: tool budget exhausted; answer with what you have"
+	}
+	tool, ok := s.tools[call.Name]
+	if !ok {
+		return fmt.Sprintf("error: unknown tool %q", call.Name)
+	}
+	metrics.Add("tools."+call.Name, 1)
+	utils.Infof("tool call %d/%d: %s %s", step, s.maxSteps, call.Name, call.Args)
+	args := call.Args
+	if len(args) == 0 {
+		args = json.RawMessage("{}")
+	}
+	out, err := tool.Call(ctx, args)
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	if len(out) > toolResultLimit {
+		out = truncateText(out, toolResultLimit) + fmt.Sprintf("\n… (truncated, %d bytes total)", len(out))
+	}
+	return out
+}
+
+// truncateText cuts text to at most limit bytes, at the last line break
+// when there is one in the second half, otherwise at a rune boundary.
+func truncateText(text string, limit int) string {
+	if len(text) <= limit {
+		return text
+	}
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	if nl := strings.LastIndexByte(text[:cut], '\n'); nl >= limit/2 {
+		cut = nl
+	}
+	return text[:cut]
+}
+
+// toolCaller is implemented by providers that support function calling.
+// Requests to other providers have their tools removed, so the prompt does
+// not mention tools they cannot use.
+type toolCaller interface {
+	callsTools()
+}
+
+func (g *GeminiReviewer) callsTools()    {}
+func (o *OpenAIReviewer) callsTools()    {}
+func (a *AnthropicReviewer) callsTools() {}
+
+// finalNudge is sent once the budget is spent.
+const finalNudge = "The tool budget for this review is used up. Give your final answer now."
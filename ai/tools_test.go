@@ -0,0 +1,69 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestToolBudgetIsPerReview(t *testing.T) {
+	calls := 0
+	tool := Tool{Name: "get_file", Call: func(context.Context, json.RawMessage) (string, error) {
+		calls++
+		return "ok", nil
+	}}
+	req := withToolBudget(ReviewRequest{Tools: []Tool{tool}, MaxToolSteps: 3})
+	// Chunks, repair retries and fallback providers each open a session.
+	sessions := []*toolSession{newToolSession(req), newToolSession(req), newToolSession(req)}
+	ctx := context.Background()
+	for _, s := range sessions {
+		for i := 0; i < 2; i++ {
+			s.run(ctx, ToolCall{Name: "get_file"})
+		}
+	}
+	if calls != 3 {
+		t.Errorf("tools ran %d times, want the budget of 3", calls)
+	}
+	for i, s := range sessions {
+		if !s.exhausted() {
+			t.Errorf("session %d does not see the spent budget", i)
+		}
+	}
+}
+
+func TestToolSessionErrors(t *testing.T) {
+	tool := Tool{Name: "fail", Call: func(context.Context, json.RawMessage) (string, error) {
+		return "", context.DeadlineExceeded
+	}}
+	s := newToolSession(ReviewRequest{Tools: []Tool{tool}, MaxToolSteps: 5})
+	if out := s.run(context.Background(), ToolCall{Name: "missing"}); !strings.Contains(out, "unknown tool") {
+		t.Errorf("unknown tool: %q", out)
+	}
+	if out := s.run(context.Background(), ToolCall{Name: "fail"}); !strings.HasPrefix(out, "error: ") {
+		t.Errorf("failing tool: %q", out)
+	}
+	if newToolSession(ReviewRequest{Tools: []Tool{tool}}) != nil {
+		t.Error("a request without a step budget got a tool session")
+	}
+}
+
+func TestTruncateText(t *testing.T) {
+	tests := []struct {
+		text  string
+		limit int
+		want  string
+	}{
+		{"short", 10, "short"},
+		{"aaaa\nbbbbbbbb", 8, "aaaa"},
+		{"a\nbbbbbbbbbb", 8, "a\nbbbbbb"},
+		{"ééééé", 5, "éé"},
+	}
+	for _, tt := range tests {
+		got := truncateText(tt.text, tt.limit)
+		if got != tt.want || !utf8.ValidString(got) {
+			t.Errorf("truncateText(%q, %d) = %q, want %q", tt.text, tt.limit, got, tt.want)
+		}
+	}
+}
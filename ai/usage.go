@@ -0,0 +1,295 @@
+package ai
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"codesage/config"
+	"codesage/utils"
+)
+
+// Price is the cost of a model in USD per million tokens.
+type Price struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+// defaultPrices covers the default models; AI_PRICES overrides or extends it.
+var defaultPrices = map[string]Price{
+	"gemini-2.5-pro":    {Input: 1.25, Output: 10},
+	"gemini-2.5-flash":  {Input: 0.30, Output: 2.50},
+	"gpt-4o-mini":       {Input: 0.15, Output: 0.60},
+	"gpt-4o":            {Input: 2.50, Output: 10},
+	"claude-sonnet-4-5": {Input: 3, Output: 15},
+	"claude-haiku-4-5":  {Input: 1, Output: 5},
+}
+
+// Attribution identifies who a provider call was made for.
+type Attribution struct {
+	Repo         string
+	Installation int64
+	PR           int
+}
+
+type attributionKey struct{}
+
+// WithAttribution tags ctx so every provider call made with it is booked
+// against the given repo, installation and PR.
+func WithAttribution(ctx context.Context, a Attribution) context.Context {
+	return context.WithValue(ctx, attributionKey{}, a)
+}
+
+func attributionFrom(ctx context.Context) Attribution {
+	a, _ := ctx.Value(attributionKey{}).(Attribution)
+	return a
+}
+
+// UsageRecord is one provider call.
+type UsageRecord struct {
+	Time             time.Time `json:"time"`
+	Repo             string    `json:"repo"`
+	Installation     int64     `json:"installation,omitempty"`
+	PR               int       `json:"pr"`
+	Provider         string    `json:"provider"`
+	Model            string    `json:"model"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	PromptVersion    string    `json:"prompt_version,omitempty"`
+	// Estimated is set when the provider did not report token counts.
+	Estimated bool    `json:"estimated,omitempty"`
+	LatencyMS int64   `json:"latency_ms"`
+	CostUSD   float64 `json:"cost_usd"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// UsageFilter selects records; zero fields match everything.
+type UsageFilter struct {
+	Repo         string
+	Installation int64
+	PR           int
+	Since, Until time.Time
+}
+
+func (f UsageFilter) match(r UsageRecord) bool {
+	return (f.Repo == "" || strings.EqualFold(f.Repo, r.Repo)) &&
+		(f.Installation == 0 || f.Installation == r.Installation) &&
+		(f.PR == 0 || f.PR == r.PR) &&
+		(f.Since.IsZero() || !r.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || r.Time.Before(f.Until))
+}
+
+// UsageTotals sums a set of records.
+type UsageTotals struct {
+	Calls            int     `json:"calls"`
+	Errors           int     `json:"errors"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+}
+
+func (t *UsageTotals) add(r UsageRecord) {
+	t.Calls++
+	if r.Error != "" {
+		t.Errors++
+	}
+	t.PromptTokens += r.PromptTokens
+	t.CompletionTokens += r.CompletionTokens
+	t.CostUSD += r.CostUSD
+}
+
+// UsageReport is what the usage API returns.
+type UsageReport struct {
+	Totals  UsageTotals            `json:"totals"`
+	ByRepo  map[string]UsageTotals `json:"by_repo"`
+	ByModel map[string]UsageTotals `json:"by_model"`
+	Records []UsageRecord          `json:"records"`
+}
+
+// maxMemoryRecords bounds the records a ledger without a file keeps; older
+// ones are dropped.
+const maxMemoryRecords = 10000
+
+// Ledger books usage records. With a file they are appended to it as JSON
+// lines and reports are read back from it, so they survive restarts and
+// nothing accumulates in memory. Without one the most recent
+// maxMemoryRecords are kept in memory.
+type Ledger struct {
+	mu      sync.Mutex
+	records []UsageRecord
+	prices  map[string]Price
+	path    string
+	file    *os.File
+}
+
+// NewLedger builds a ledger, appending to the file at path when it is set.
+func NewLedger(path string, prices map[string]Price) (*Ledger, error) {
+	l := &Ledger{prices: map[string]Price{}, path: path}
+	for model, p := range defaultPrices {
+		l.prices[model] = p
+	}
+	for model, p := range prices {
+		l.prices[strings.ToLower(model)] = p
+	}
+	if path == "" {
+		return l, nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	l.file = f
+	return l, nil
+}
+
+// Cost estimates the USD cost of a call. Unknown models cost 0.
+func (l *Ledger) Cost(model string, u Usage) float64 {
+	p, ok := l.prices[strings.ToLower(model)]
+	if !ok {
+		return 0
+	}
+	return (float64(u.PromptTokens)*p.Input + float64(u.CompletionTokens)*p.Output) / 1e6
+}
+
+// Record stores r.
+func (l *Ledger) Record(r UsageRecord) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		if len(l.records) >= maxMemoryRecords {
+			l.records = l.records[1:]
+		}
+		l.records = append(l.records, r)
+		return
+	}
+	data, _ := json.Marshal(r)
+	// One write per line, so readers never see half of one followed by more.
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		utils.Errorf("usage ledger: %v", err)
+	}
+}
+
+// each calls fn with every stored record, streaming them from the file if
+// there is one.
+func (l *Ledger) each(fn func(UsageRecord)) error {
+	if l.file == nil {
+		l.mu.Lock()
+		records := l.records
+		l.mu.Unlock()
+		for _, r := range records {
+			fn(r)
+		}
+		return nil
+	}
+	f, err := os.Open(l.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var r UsageRecord
+		// Skip lines that do not parse, such as one still being written.
+		if json.Unmarshal(scanner.Bytes(), &r) == nil {
+			fn(r)
+		}
+	}
+	return scanner.Err()
+}
+
+// Report returns the records matching f with totals per repo and model.
+func (l *Ledger) Report(f UsageFilter) (UsageReport, error) {
+	report := UsageReport{ByRepo: map[string]UsageTotals{}, ByModel: map[string]UsageTotals{}, Records: []UsageRecord{}}
+	err := l.each(func(r UsageRecord) {
+		if !f.match(r) {
+			return
+		}
+		report.Records = append(report.Records, r)
+		report.Totals.add(r)
+		repo := report.ByRepo[r.Repo]
+		repo.add(r)
+		report.ByRepo[r.Repo] = repo
+		model := report.ByModel[r.Provider+"/"+r.Model]
+		model.add(r)
+		report.ByModel[r.Provider+"/"+r.Model] = model
+	})
+	if err != nil {
+		return UsageReport{}, err
+	}
+	sort.SliceStable(report.Records, func(i, j int) bool { return report.Records[i].Time.Before(report.Records[j].Time) })
+	return report, nil
+}
+
+var (
+	ledgerOnce sync.Once
+	ledger     *Ledger
+)
+
+// UsageLedger returns the process-wide ledger configured by cfg.
+func UsageLedger(cfg *config.Config) *Ledger {
+	ledgerOnce.Do(func() {
+		prices := map[string]Price{}
+		for model, p := range cfg.AIPrices {
+			prices[model] = Price{Input: p[0], Output: p[1]}
+		}
+		var err error
+		ledger, err = NewLedger(cfg.AIUsageLog, prices)
+		if err != nil {
+			utils.Errorf("usage ledger %s unavailable, keeping usage in memory only: %v", cfg.AIUsageLog, err)
+			ledger, _ = NewLedger("", prices)
+		}
+	})
+	return ledger
+}
+
+// metered records every call made to a concrete provider.
+type metered struct {
+	Reviewer
+	model  string
+	ledger *Ledger
+}
+
+func (m *metered) Review(ctx context.Context, req ReviewRequest) (*Review, error) {
+	if _, ok := m.Reviewer.(toolCaller); !ok {
+		req.Tools = nil
+	}
+	start := time.Now()
+	review, err := m.Reviewer.Review(ctx, req)
+	attr := attributionFrom(ctx)
+	rec := UsageRecord{
+		Time:          start.UTC(),
+		Repo:          attr.Repo,
+		Installation:  attr.Installation,
+		PR:            attr.PR,
+		Provider:      m.Name(),
+		Model:         m.model,
+		PromptVersion: promptsOf(req).Version(),
+		LatencyMS:     time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		rec.Error = err.Error()
+		m.ledger.Record(rec)
+		return nil, err
+	}
+	if review.Model != "" {
+		rec.Model = review.Model
+	}
+	if review.Usage.PromptTokens == 0 && review.Usage.CompletionTokens == 0 {
+		// The provider did not report usage; estimate from the text.
+		review.Usage.PromptTokens = EstimateTokens(reviewPrompt(req))
+		review.Usage.CompletionTokens = EstimateTokens(review.Text)
+		rec.Estimated = true
+	}
+	review.Usage.CostUSD = m.ledger.Cost(rec.Model, review.Usage)
+	rec.PromptTokens = review.Usage.PromptTokens
+	rec.CompletionTokens = review.Usage.CompletionTokens
+	rec.CostUSD = review.Usage.CostUSD
+	m.ledger.Record(rec)
+	return review, nil
+}
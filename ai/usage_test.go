@@ -0,0 +1,47 @@
+package ai
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLedgerReadsBackItsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.jsonl")
+	l, err := NewLedger(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2026, 9, 1, 12, 0, 0, 0, time.UTC)
+	l.Record(UsageRecord{Time: day, Repo: "o/a", Provider: "openai", Model: "gpt-4o-mini", PromptTokens: 100, CostUSD: 0.5})
+	l.Record(UsageRecord{Time: day.Add(time.Hour), Repo: "o/b", Provider: "openai", Model: "gpt-4o-mini", Error: "boom"})
+
+	// A restarted process reports the calls booked before it started.
+	again, err := NewLedger(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again.Record(UsageRecord{Time: day.AddDate(0, 0, 1), Repo: "o/a", Provider: "gemini", Model: "gemini-2.5-pro", PromptTokens: 10})
+	report, err := again.Report(UsageFilter{Repo: "O/A"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Records) != 2 || report.Totals.PromptTokens != 110 || len(report.ByModel) != 2 {
+		t.Errorf("report = %+v, want both calls of o/a", report)
+	}
+	report, _ = again.Report(UsageFilter{Until: day.AddDate(0, 0, 1)})
+	if report.Totals.Calls != 2 || report.Totals.Errors != 1 {
+		t.Errorf("totals = %+v, want 2 calls with 1 error", report.Totals)
+	}
+}
+
+func TestLedgerInMemoryIsBounded(t *testing.T) {
+	l, _ := NewLedger("", nil)
+	for i := 0; i < maxMemoryRecords+5; i++ {
+		l.Record(UsageRecord{PR: i})
+	}
+	report, _ := l.Report(UsageFilter{})
+	if len(report.Records) != maxMemoryRecords || report.Records[0].PR != 5 {
+		t.Errorf("kept %d records starting at PR %d, want the last %d", len(report.Records), report.Records[0].PR, maxMemoryRecords)
+	}
+}
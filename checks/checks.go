@@ -0,0 +1,198 @@
+// Package checks runs deterministic, rule-based checks over a pull request
+// diff. They need no AI provider, so a PR still gets feedback when every
+// provider is down or out of quota.
+package checks
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"codesage/ai"
+)
+
+// File is a changed file as the rules see it.
+type File struct {
+	Path  string
+	Patch string
+	Lines []ai.DiffLine
+}
+
+// Ext returns the file extension without the dot, e.g. "go".
+func (f File) Ext() string {
+	return strings.TrimPrefix(path.Ext(f.Path), ".")
+}
+
+// Added returns the added lines of the file.
+func (f File) Added() []ai.DiffLine {
+	var added []ai.DiffLine
+	for _, l := range f.Lines {
+		if l.Kind == '+' {
+			added = append(added, l)
+		}
+	}
+	return added
+}
+
+// Rule is a single check. Rules with a Line func are run on every added
+// line; rules with a File func see the whole file. Findings get the rule's
+// ID, severity and category unless they set their own.
+type Rule struct {
+	ID          string
+	Description string
+	Severity    ai.Severity
+	Category    string
+	// Extensions limits the rule to files with these extensions; all files
+	// when empty.
+	Extensions []string
+	// Line returns a message when the added line breaks the rule.
+	Line func(f File, line ai.DiffLine) (string, bool)
+	// File returns findings for the whole file.
+	File func(f File) []ai.Finding
+}
+
+func (r Rule) applies(f File) bool {
+	if len(r.Extensions) == 0 {
+		return true
+	}
+	for _, ext := range r.Extensions {
+		if strings.EqualFold(ext, f.Ext()) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Rule{}
+)
+
+// Register makes a rule available. Built-in rules call it from init.
+func Register(r Rule) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, dup := registry[r.ID]; dup {
+		panic("checks: rule registered twice: " + r.ID)
+	}
+	if r.Line == nil && r.File == nil {
+		panic("checks: rule has nothing to check: " + r.ID)
+	}
+	registry[r.ID] = r
+}
+
+// Rules returns every registered rule, sorted by ID.
+func Rules() []Rule {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	rules := make([]Rule, 0, len(registry))
+	for _, r := range registry {
+		rules = append(rules, r)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+	return rules
+}
+
+// Run applies every registered rule except those in disabled to files.
+// Only added lines are checked, so a PR is never blamed for old code.
+func Run(files []ai.FileDiff, disabled []string) []ai.Finding {
+	skip := map[string]bool{}
+	for _, id := range disabled {
+		skip[strings.ToLower(id)] = true
+	}
+	var findings []ai.Finding
+	for _, fd := range files {
+		f := File{Path: fd.Path, Patch: fd.Patch, Lines: ai.ParsePatch(fd.Patch)}
+		for _, r := range Rules() {
+			if skip[strings.ToLower(r.ID)] || !r.applies(f) {
+				continue
+			}
+			if r.Line != nil {
+				for _, line := range f.Added() {
+					if msg, ok := r.Line(f, line); ok {
+						findings = append(findings, r.finding(ai.Finding{File: f.Path, StartLine: line.NewLine, Message: msg}))
+					}
+				}
+			}
+			if r.File != nil {
+				for _, finding := range r.File(f) {
+					finding.File = f.Path
+					findings = append(findings, r.finding(finding))
+				}
+			}
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].Severity != findings[j].Severity {
+			return severityRank(findings[i].Severity) < severityRank(findings[j].Severity)
+		}
+		return findings[i].File < findings[j].File ||
+			findings[i].File == findings[j].File && findings[i].StartLine < findings[j].StartLine
+	})
+	return findings
+}
+
+func (r Rule) finding(f ai.Finding) ai.Finding {
+	f.Rule = r.ID
+	if f.Severity == "" {
+		f.Severity = r.Severity
+	}
+	if f.Category == "" {
+		f.Category = r.Category
+	}
+	if f.EndLine < f.StartLine {
+		f.EndLine = f.StartLine
+	}
+	return f
+}
+
+func severityRank(s ai.Severity) int {
+	switch s {
+	case ai.SeverityCritical:
+		return 0
+	case ai.SeverityMajor:
+		return 1
+	case ai.SeverityMinor:
+		return 2
+	}
+	return 3
+}
+
+// Merge drops static findings that an AI finding already reports on the
+// same file and line, so the comment does not say the same thing twice.
+func Merge(static, aiFindings []ai.Finding) []ai.Finding {
+	var out []ai.Finding
+	for _, s := range static {
+		covered := false
+		for _, a := range aiFindings {
+			if a.File == s.File && a.StartLine <= s.StartLine && s.StartLine <= a.EndLine && a.Category == s.Category {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// Summary describes the findings in one line for logs.
+func Summary(findings []ai.Finding) string {
+	counts := map[string]int{}
+	for _, f := range findings {
+		counts[f.Rule]++
+	}
+	ids := make([]string, 0, len(counts))
+	for id := range counts {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = fmt.Sprintf("%s=%d", id, counts[id])
+	}
+	return strings.Join(parts, ", ")
+}
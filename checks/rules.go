@@ -0,0 +1,243 @@
+package checks
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"codesage/ai"
+)
+
+// maxFunctionLines is the length above which a new function is reported.
+const maxFunctionLines = 80
+
+func init() {
+	Register(Rule{
+		ID:          "debug-print",
+		Description: "Debug output or breakpoints left in the code",
+		Severity:    ai.SeverityMinor,
+		Category:    "maintainability",
+		Extensions:  []string{"js", "jsx", "ts", "tsx", "mjs", "py", "rb", "php", "go"},
+		Line:        checkDebugPrint,
+	})
+	Register(Rule{
+		ID:          "todo",
+		Description: "TODO, FIXME, XXX or HACK markers introduced",
+		Severity:    ai.SeverityInfo,
+		Category:    "maintainability",
+		Line:        matchLine(regexp.MustCompile(`\b(TODO|FIXME|XXX|HACK)\b`), "New `%s` marker; consider opening an issue so it is not forgotten."),
+	})
+	Register(Rule{
+		ID:          "go-print-in-library",
+		Description: "fmt.Print* in non-main Go packages",
+		Severity:    ai.SeverityMinor,
+		Category:    "maintainability",
+		Extensions:  []string{"go"},
+		Line:        checkGoPrint,
+	})
+	Register(Rule{
+		ID:          "insecure-tls",
+		Description: "TLS certificate verification disabled",
+		Severity:    ai.SeverityMajor,
+		Category:    "security",
+		Line: matchLine(regexp.MustCompile(`InsecureSkipVerify:\s*true|\bverify\s*=\s*False\b|rejectUnauthorized\s*:\s*false|NODE_TLS_REJECT_UNAUTHORIZED\s*=\s*["']?0|CURLOPT_SSL_VERIFYPEER\s*,\s*(false|0)|ssl\._create_unverified_context|\bcurl\b.*\s(-k|--insecure)\b`),
+			"TLS certificate verification is disabled (`%s`), which allows man-in-the-middle attacks. Trust the right CA instead."),
+	})
+	Register(Rule{
+		ID:          "hardcoded-credential",
+		Description: "API keys, tokens, passwords or private keys in source",
+		Severity:    ai.SeverityCritical,
+		Category:    "security",
+		Line:        checkCredential,
+	})
+	Register(Rule{
+		ID:          "huge-function",
+		Description: fmt.Sprintf("New functions longer than %d lines", maxFunctionLines),
+		Severity:    ai.SeverityMinor,
+		Category:    "maintainability",
+		Extensions:  []string{"go", "js", "jsx", "ts", "tsx", "java", "kt", "rs", "c", "cc", "cpp", "cs", "php", "swift", "py"},
+		File:        checkFunctionLength,
+	})
+}
+
+// matchLine builds a Line check from a pattern; format receives the match.
+func matchLine(re *regexp.Regexp, format string) func(File, ai.DiffLine) (string, bool) {
+	return func(_ File, line ai.DiffLine) (string, bool) {
+		m := re.FindString(line.Text)
+		if m == "" {
+			return "", false
+		}
+		return fmt.Sprintf(format, strings.TrimSpace(m)), true
+	}
+}
+
+var debugPatterns = map[string]*regexp.Regexp{
+	"js":  regexp.MustCompile(`\bconsole\.(log|debug|trace|dir)\(|^\s*debugger\b`),
+	"py":  regexp.MustCompile(`^\s*print\(|\bpdb\.set_trace\(|\bbreakpoint\(\)`),
+	"rb":  regexp.MustCompile(`\bbinding\.(pry|irb)\b|^\s*byebug\b`),
+	"php": regexp.MustCompile(`\b(var_dump|print_r|dd)\(`),
+	"go":  regexp.MustCompile(`^\s*(println|print)\(|\bspew\.Dump\(`),
+}
+
+var extFamily = map[string]string{"jsx": "js", "ts": "js", "tsx": "js", "mjs": "js"}
+
+func checkDebugPrint(f File, line ai.DiffLine) (string, bool) {
+	ext := f.Ext()
+	if family, ok := extFamily[ext]; ok {
+		ext = family
+	}
+	re := debugPatterns[ext]
+	if re == nil || isComment(line.Text) {
+		return "", false
+	}
+	if m := re.FindString(line.Text); m != "" {
+		return fmt.Sprintf("Debug statement `%s` looks left over; remove it or use the project's logger.", strings.TrimSuffix(strings.TrimSpace(m), "(")), true
+	}
+	return "", false
+}
+
+var goPrintPattern = regexp.MustCompile(`\bfmt\.Print(ln|f)?\(`)
+
+func checkGoPrint(f File, line ai.DiffLine) (string, bool) {
+	if strings.HasSuffix(f.Path, "_test.go") || isGoMain(f) || isComment(line.Text) {
+		return "", false
+	}
+	if m := goPrintPattern.FindString(line.Text); m != "" {
+		return fmt.Sprintf("`%s` in a library package writes to the caller's stdout; return the value or use a logger.", strings.TrimSuffix(m, "(")), true
+	}
+	return "", false
+}
+
+// isGoMain guesses whether a Go file belongs to package main. The package
+// clause is often outside the patch, so cmd/ directories and main.go count.
+func isGoMain(f File) bool {
+	for _, l := range f.Lines {
+		if strings.HasPrefix(strings.TrimSpace(l.Text), "package ") {
+			return strings.TrimSpace(l.Text) == "package main"
+		}
+	}
+	return path.Base(f.Path) == "main.go" || strings.HasPrefix(f.Path, "cmd/") || strings.Contains(f.Path, "/cmd/")
+}
+
+// credentialFinder reuses the redaction rules to spot secrets.
+var credentialFinder, _ = ai.NewRedactor(nil, nil)
+
+// privateKeyHeader starts a PEM private key. The redaction rule needs the
+// whole block, which a single line never holds.
+var privateKeyHeader = regexp.MustCompile(`-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----`)
+
+func checkCredential(f File, line ai.DiffLine) (string, bool) {
+	if strings.Contains(f.Path, "testdata/") || strings.Contains(f.Path, "fixtures/") {
+		return "", false
+	}
+	var kinds []string
+	for _, kind := range credentialFinder.Find(line.Text) {
+		if kind != "email" {
+			kinds = append(kinds, strings.ReplaceAll(kind, "_", " "))
+		}
+	}
+	if privateKeyHeader.MatchString(line.Text) && !slices.Contains(kinds, "private key") {
+		kinds = append(kinds, "private key")
+	}
+	if len(kinds) == 0 {
+		return "", false
+	}
+	// Never repeat the value itself: the comment is visible to everyone.
+	return fmt.Sprintf("Possible hardcoded credential (%s). Load it from configuration or a secret store, and rotate it if it was real.", strings.Join(kinds, ", ")), true
+}
+
+var (
+	braceFuncStart  = regexp.MustCompile(`^\s*(func\b|(export\s+)?(async\s+)?function\b|(pub(\([a-z]+\))?\s+)?(async\s+)?fn\b|((public|private|protected|internal|static|final|override|abstract|async|virtual)\s+)+[\w<>\[\],.?]+\s+\w+\s*\()`)
+	pythonFuncStart = regexp.MustCompile(`^(\s*)(async\s+)?def\s+\w+`)
+)
+
+// checkFunctionLength reports functions that are added by this PR and
+// longer than maxFunctionLines. Brace languages are measured by brace
+// depth, Python by indentation.
+func checkFunctionLength(f File) []ai.Finding {
+	var newSide []ai.DiffLine
+	for _, l := range f.Lines {
+		if l.Kind != '-' {
+			newSide = append(newSide, l)
+		}
+	}
+	var findings []ai.Finding
+	for i := 0; i < len(newSide); i++ {
+		start := newSide[i]
+		if start.Kind != '+' || isComment(start.Text) {
+			continue
+		}
+		var end int
+		if f.Ext() == "py" {
+			m := pythonFuncStart.FindStringSubmatch(start.Text)
+			if m == nil {
+				continue
+			}
+			end = pythonFunctionEnd(newSide, i, len(m[1]))
+		} else {
+			if !braceFuncStart.MatchString(start.Text) {
+				continue
+			}
+			end = braceFunctionEnd(newSide, i)
+		}
+		if end < 0 {
+			continue
+		}
+		length := newSide[end].NewLine - start.NewLine + 1
+		if length > maxFunctionLines {
+			findings = append(findings, ai.Finding{
+				StartLine: start.NewLine,
+				EndLine:   newSide[end].NewLine,
+				Message:   fmt.Sprintf("This new function is %d lines long (limit %d). Consider splitting it into smaller, testable pieces.", length, maxFunctionLines),
+			})
+			i = end
+		}
+	}
+	return findings
+}
+
+// braceFunctionEnd returns the index of the line closing the function that
+// starts at lines[start], or -1 if it does not close within the patch.
+func braceFunctionEnd(lines []ai.DiffLine, start int) int {
+	depth, opened := 0, false
+	for i := start; i < len(lines); i++ {
+		if i > start && lines[i].NewLine != lines[i-1].NewLine+1 {
+			return -1 // the function spans a gap between hunks
+		}
+		depth += strings.Count(lines[i].Text, "{") - strings.Count(lines[i].Text, "}")
+		if strings.Contains(lines[i].Text, "{") {
+			opened = true
+		}
+		if opened && depth <= 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// pythonFunctionEnd returns the index of the last line of the function
+// starting at lines[start], whose def is indented by indent.
+func pythonFunctionEnd(lines []ai.DiffLine, start, indent int) int {
+	end := start
+	for i := start + 1; i < len(lines); i++ {
+		if lines[i].NewLine != lines[i-1].NewLine+1 {
+			return -1
+		}
+		text := strings.TrimRight(lines[i].Text, " \t")
+		if text == "" {
+			continue
+		}
+		if len(text)-len(strings.TrimLeft(text, " \t")) <= indent {
+			return end
+		}
+		end = i
+	}
+	return end
+}
+
+func isComment(text string) bool {
+	t := strings.TrimSpace(text)
+	return strings.HasPrefix(t, "//") || strings.HasPrefix(t, "#") || strings.HasPrefix(t, "/*") || strings.HasPrefix(t, "*")
+}
@@ -3,6 +3,9 @@ package config
 import (
 	"os"
 	"log"
+	"strconv"
+	"strings"
+	"time"
  	 "github.com/joho/godotenv"
 )
 
@@ -10,8 +13,56 @@ type Config struct {
 	Port        string
 	GitHubToken string
 	OpenAIKey   string
+	OpenAIBaseURL string
 	GeminiKey   string
-	huggingfaceKey string
+	GeminiSafety map[string]string
+	AnthropicKey string
+	HuggingFaceKey string
+	OllamaHost  string
+	AIProvider  string
+	AIProviders []string
+	AIBreakerThreshold int
+	AIBreakerCooldown time.Duration
+	AIRepairRetries int
+	AITokenBudget int
+	AITokenBudgets map[string]int
+	AIChunkConcurrency int
+	AIMaxChunks int
+	AIRequestTimeout time.Duration
+	AIReviewTimeout time.Duration
+	AIMaxRetries int
+	AIRateLimit int
+	AIRateLimits map[string]int
+	AIRateBurst int
+	AICache     string
+	AICacheDir  string
+	AICacheTTL  time.Duration
+	AICacheMaxEntries int
+	AICacheBypassLabel string
+	AIPrices    map[string][2]float64
+	AIUsageLog  string
+	UsageAPIToken string
+	AIMockScript string
+	AIMockTemplate string
+	AIHTTPMode  string
+	AIFixturesDir string
+	AIPromptsDir string
+	AIStreaming bool
+	AIRedact    bool
+	StaticChecks string
+	StaticChecksDisabled []string
+	AIRedactPatterns map[string]string
+	AIRedactRestore []string
+	AIStreamInterval time.Duration
+	AIReviewMode string
+	AIAgentMaxSteps int
+	AIContextBudget int
+	ReviewComments string
+	Models      map[string]ModelParams
+	LargeModels map[string]ModelParams
+	LargePRLines int
+	Repos       map[string]RepoSettings
+	GitHubAPIURL string
 	GitHubAppID string
 	GitHubAppPrivateKey string
 	GitHubWebhookSecret string
@@ -23,12 +74,61 @@ func Load() *Config {
 	if err := godotenv.Load(); err != nil {
 		log.Println(" No .env file found, falling back to system environment variables")
 	}
+	models, largeModels := loadModels()
 	return &Config{
 		Port:        getEnv("PORT", "8080"),
 		GitHubToken: os.Getenv("GITHUB_TOKEN"),
 		OpenAIKey:   os.Getenv("OPENAI_API_KEY"),
+		OpenAIBaseURL: getEnv("OPENAI_BASE_URL", "https://api.openai.com/v1"),
 		GeminiKey:   os.Getenv("GEMINI_API_KEY"),
-		huggingfaceKey:  os.Getenv("HF_API_KEY"),
+		GeminiSafety: getEnvMap("GEMINI_SAFETY_SETTINGS"),
+		AnthropicKey: os.Getenv("ANTHROPIC_API_KEY"),
+		HuggingFaceKey:  os.Getenv("HF_API_KEY"),
+		OllamaHost:  getEnv("OLLAMA_HOST", "http://localhost:11434"),
+		AIProvider:  getEnv("AI_PROVIDER", "gemini"),
+		AIProviders: getEnvList("AI_PROVIDERS"),
+		AIBreakerThreshold: getEnvInt("AI_BREAKER_THRESHOLD", 3),
+		AIBreakerCooldown: getEnvDuration("AI_BREAKER_COOLDOWN", 5*time.Minute),
+		AIRepairRetries: getEnvInt("AI_REPAIR_RETRIES", 1),
+		AITokenBudget: getEnvInt("AI_TOKEN_BUDGET", 6000),
+		AITokenBudgets: getEnvIntMap("AI_TOKEN_BUDGETS"),
+		AIChunkConcurrency: getEnvInt("AI_CHUNK_CONCURRENCY", 4),
+		AIMaxChunks: getEnvInt("AI_MAX_CHUNKS", 20),
+		AIRequestTimeout: getEnvDuration("AI_REQUEST_TIMEOUT", 2*time.Minute),
+		AIReviewTimeout: getEnvDuration("AI_REVIEW_TIMEOUT", 10*time.Minute),
+		AIMaxRetries: getEnvInt("AI_MAX_RETRIES", 3),
+		AIRateLimit: getEnvInt("AI_RATE_LIMIT", 60),
+		AIRateLimits: getEnvIntMap("AI_RATE_LIMITS"),
+		AIRateBurst: getEnvInt("AI_RATE_BURST", 5),
+		AICache:     getEnv("AI_CACHE", "memory"),
+		AICacheDir:  getEnv("AI_CACHE_DIR", ".codesage-cache"),
+		AICacheTTL:  getEnvDuration("AI_CACHE_TTL", 7*24*time.Hour),
+		AICacheMaxEntries: getEnvInt("AI_CACHE_MAX_ENTRIES", 500),
+		AICacheBypassLabel: getEnv("AI_CACHE_BYPASS_LABEL", "codesage:fresh-review"),
+		AIPrices:    getEnvPrices("AI_PRICES"),
+		AIUsageLog:  os.Getenv("AI_USAGE_LOG"),
+		UsageAPIToken: os.Getenv("USAGE_API_TOKEN"),
+		AIMockScript: os.Getenv("AI_MOCK_SCRIPT"),
+		AIMockTemplate: os.Getenv("AI_MOCK_TEMPLATE"),
+		AIHTTPMode:  os.Getenv("AI_HTTP_MODE"),
+		AIFixturesDir: getEnv("AI_FIXTURES_DIR", "ai/testdata/fixtures"),
+		AIPromptsDir: os.Getenv("AI_PROMPTS_DIR"),
+		AIStreaming: getEnvBool("AI_STREAMING", true),
+		AIRedact:    getEnvBool("AI_REDACT", true),
+		StaticChecks: strings.ToLower(getEnv("STATIC_CHECKS", "on")),
+		StaticChecksDisabled: getEnvList("STATIC_CHECKS_DISABLE"),
+		AIRedactPatterns: loadRedactPatterns(os.Getenv("AI_REDACT_PATTERNS_FILE")),
+		AIRedactRestore: getEnvListDefault("AI_REDACT_RESTORE", []string{"email"}),
+		AIStreamInterval: getEnvDuration("AI_STREAM_INTERVAL", 3*time.Second),
+		AIReviewMode: strings.ToLower(getEnv("AI_REVIEW_MODE", "diff")),
+		AIAgentMaxSteps: getEnvInt("AI_AGENT_MAX_STEPS", 8),
+		AIContextBudget: getEnvInt("AI_CONTEXT_BUDGET", 3000),
+		ReviewComments: strings.ToLower(getEnv("REVIEW_COMMENTS", "inline")),
+		Models:      models,
+		LargeModels: largeModels,
+		LargePRLines: getEnvInt("AI_LARGE_PR_LINES", 400),
+		Repos:       loadRepoSettings(os.Getenv("REPO_SETTINGS_FILE")),
+		GitHubAPIURL: getEnv("GITHUB_API_URL", "https://api.github.com"),
 		GitHubAppID: os.Getenv("GITHUB_APP_ID"),
 		GitHubAppPrivateKey: os.Getenv("GITHUB_APP_PRIVATE_KEY"),
 		GitHubWebhookSecret: os.Getenv("GITHUB_WEBHOOK_SECRET"),
@@ -42,3 +142,130 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+func getEnvInt(key string, fallback int) int {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		log.Printf(" Invalid %s=%q, using default %d", key, val, fallback)
+		return fallback
+	}
+	return n
+}
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		log.Printf(" Invalid %s=%q, using default %s", key, val, fallback)
+		return fallback
+	}
+	return d
+}
+func getEnvBool(key string, fallback bool) bool {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		log.Printf(" Invalid %s=%q, using default %t", key, val, fallback)
+		return fallback
+	}
+	return b
+}
+// getEnvList splits a comma-separated variable, dropping empty entries.
+func getEnvList(key string) []string {
+	var out []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+// getEnvMap parses "name=value,other=value" into a map with lowercased keys.
+func getEnvMap(key string) map[string]string {
+	out := map[string]string{}
+	for _, item := range getEnvList(key) {
+		name, val, ok := strings.Cut(item, "=")
+		if !ok {
+			log.Printf(" Invalid entry %q in %s, ignoring", item, key)
+			continue
+		}
+		out[strings.ToLower(strings.TrimSpace(name))] = strings.TrimSpace(val)
+	}
+	return out
+}
+// getEnvListDefault is getEnvList with a fallback for an unset variable.
+// Set the variable to "none" for an empty list.
+func getEnvListDefault(key string, fallback []string) []string {
+	if os.Getenv(key) == "" {
+		return fallback
+	}
+	list := getEnvList(key)
+	if len(list) == 1 && strings.EqualFold(list[0], "none") {
+		return nil
+	}
+	return list
+}
+// getEnvIntMap parses "name=123,other=456" into a map with lowercased keys.
+func getEnvIntMap(key string) map[string]int {
+	out := map[string]int{}
+	for _, item := range getEnvList(key) {
+		name, val, ok := strings.Cut(item, "=")
+		n, err := strconv.Atoi(strings.TrimSpace(val))
+		if !ok || err != nil {
+			log.Printf(" Invalid entry %q in %s, ignoring", item, key)
+			continue
+		}
+		out[strings.ToLower(strings.TrimSpace(name))] = n
+	}
+	return out
+}
+// getEnvPrices parses "model=input:output,..." with USD per million tokens.
+func getEnvPrices(key string) map[string][2]float64 {
+	out := map[string][2]float64{}
+	for _, item := range getEnvList(key) {
+		model, price, _ := strings.Cut(item, "=")
+		in, outPrice, ok := strings.Cut(price, ":")
+		inF, err1 := strconv.ParseFloat(strings.TrimSpace(in), 64)
+		outF, err2 := strconv.ParseFloat(strings.TrimSpace(outPrice), 64)
+		if !ok || err1 != nil || err2 != nil {
+			log.Printf(" Invalid entry %q in %s, ignoring", item, key)
+			continue
+		}
+		out[strings.TrimSpace(model)] = [2]float64{inF, outF}
+	}
+	return out
+}
+// loadRedactPatterns reads custom redaction rules, one "kind=regexp" per
+// line; blank lines and lines starting with # are ignored.
+func loadRedactPatterns(path string) map[string]string {
+	out := map[string]string{}
+	if path == "" {
+		return out
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf(" Could not read redaction patterns %s: %v", path, err)
+		return out
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kind, pattern, ok := strings.Cut(line, "=")
+		if !ok || strings.TrimSpace(kind) == "" {
+			log.Printf(" Invalid line %q in %s, ignoring", line, path)
+			continue
+		}
+		out[strings.ToLower(strings.TrimSpace(kind))] = strings.TrimSpace(pattern)
+	}
+	return out
+}
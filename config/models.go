@@ -0,0 +1,103 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ModelParams are the generation settings for one provider. Zero values
+// mean "use the provider's default".
+type ModelParams struct {
+	Model             string   `json:"model,omitempty"`
+	Temperature       *float64 `json:"temperature,omitempty"`
+	TopP              *float64 `json:"top_p,omitempty"`
+	MaxTokens         int      `json:"max_tokens,omitempty"`
+	StopSequences     []string `json:"stop,omitempty"`
+	SystemInstruction string   `json:"system_instruction,omitempty"`
+}
+
+// Merge returns p with every field that is set in override replaced.
+func (p ModelParams) Merge(override ModelParams) ModelParams {
+	if override.Model != "" {
+		p.Model = override.Model
+	}
+	if override.Temperature != nil {
+		p.Temperature = override.Temperature
+	}
+	if override.TopP != nil {
+		p.TopP = override.TopP
+	}
+	if override.MaxTokens > 0 {
+		p.MaxTokens = override.MaxTokens
+	}
+	if len(override.StopSequences) > 0 {
+		p.StopSequences = override.StopSequences
+	}
+	if override.SystemInstruction != "" {
+		p.SystemInstruction = override.SystemInstruction
+	}
+	return p
+}
+
+// providerEnv maps provider names to their environment variable prefix and
+// default model.
+var providerEnv = []struct {
+	name, prefix, model string
+	maxTokens           int
+}{
+	{"gemini", "GEMINI", "gemini-2.5-pro", 0},
+	{"openai", "OPENAI", "gpt-4o-mini", 0},
+	{"anthropic", "ANTHROPIC", "claude-sonnet-4-5", 2048},
+	{"ollama", "OLLAMA", "qwen2.5-coder", 0},
+	{"huggingface", "HF", "meta-llama/CodeLlama-7b-Instruct-hf", 1024},
+}
+
+// loadModels reads <PREFIX>_MODEL, _TEMPERATURE, _TOP_P, _MAX_TOKENS, _STOP
+// and _SYSTEM_INSTRUCTION for every provider, plus <PREFIX>_MODEL_LARGE for
+// the model used on large PRs.
+func loadModels() (models, large map[string]ModelParams) {
+	models = map[string]ModelParams{}
+	large = map[string]ModelParams{}
+	for _, p := range providerEnv {
+		models[p.name] = ModelParams{
+			Model:             getEnv(p.prefix+"_MODEL", p.model),
+			Temperature:       getEnvFloat(p.prefix + "_TEMPERATURE"),
+			TopP:              getEnvFloat(p.prefix + "_TOP_P"),
+			MaxTokens:         getEnvInt(p.prefix+"_MAX_TOKENS", p.maxTokens),
+			StopSequences:     getEnvList(p.prefix + "_STOP"),
+			SystemInstruction: os.Getenv(p.prefix + "_SYSTEM_INSTRUCTION"),
+		}
+		if m := os.Getenv(p.prefix + "_MODEL_LARGE"); m != "" {
+			large[p.name] = ModelParams{Model: m}
+		}
+	}
+	return models, large
+}
+
+func getEnvFloat(key string) *float64 {
+	val := os.Getenv(key)
+	if val == "" {
+		return nil
+	}
+	f, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		log.Printf(" Invalid %s=%q, ignoring", key, val)
+		return nil
+	}
+	return &f
+}
+
+// mergeModels overlays overrides on base without modifying either map.
+func mergeModels(base, overrides map[string]ModelParams) map[string]ModelParams {
+	out := make(map[string]ModelParams, len(base))
+	for name, p := range base {
+		out[name] = p
+	}
+	for name, p := range overrides {
+		name = strings.ToLower(name)
+		out[name] = out[name].Merge(p)
+	}
+	return out
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+)
+
+// RepoSettings overrides deployment settings for a single repository.
+// They are read from the JSON file named by REPO_SETTINGS_FILE, keyed by
+// "owner/repo":
+//
+//	{
+//	  "acme/api": {
+//	    "provider": "openai",
+//	    "models": {"openai": {"model": "gpt-4o-mini", "temperature": 0.1}},
+//	    "large_pr_lines": 300,
+//	    "large_models": {"openai": {"model": "gpt-4o"}},
+//	    "prompts_dir": "/etc/codesage/prompts/acme-api",
+//	    "review_mode": "agent"
+//	  }
+//	}
+type RepoSettings struct {
+	Provider     string                 `json:"provider,omitempty"`
+	Providers    []string               `json:"providers,omitempty"`
+	Models       map[string]ModelParams `json:"models,omitempty"`
+	LargePRLines int                    `json:"large_pr_lines,omitempty"`
+	LargeModels  map[string]ModelParams `json:"large_models,omitempty"`
+	// PromptsDir holds prompt templates that replace the deployment's.
+	PromptsDir string `json:"prompts_dir,omitempty"`
+	// ReviewMode is "diff" or "agent"; see AI_REVIEW_MODE.
+	ReviewMode string `json:"review_mode,omitempty"`
+}
+
+func loadRepoSettings(path string) map[string]RepoSettings {
+	repos := map[string]RepoSettings{}
+	if path == "" {
+		return repos
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf(" Could not read repo settings %s: %v", path, err)
+		return repos
+	}
+	var raw map[string]RepoSettings
+	if err := json.Unmarshal(data, &raw); err != nil {
+		log.Printf(" Invalid repo settings %s: %v", path, err)
+		return repos
+	}
+	for name, settings := range raw {
+		repos[strings.ToLower(name)] = settings
+	}
+	return repos
+}
+
+// ForPR returns a copy of c with the settings for repo ("owner/name")
+// applied. PRs with at least LargePRLines changed lines use the large-PR
+// models. The receiver is never modified.
+func (c *Config) ForPR(repo string, changedLines int) *Config {
+	out := *c
+	out.Models = mergeModels(c.Models, nil)
+	threshold := c.LargePRLines
+	large := c.LargeModels
+
+	if rs, ok := c.Repos[strings.ToLower(repo)]; ok {
+		if rs.Provider != "" {
+			out.AIProvider = rs.Provider
+			out.AIProviders = nil
+		}
+		if len(rs.Providers) > 0 {
+			out.AIProviders = rs.Providers
+		}
+		out.Models = mergeModels(out.Models, rs.Models)
+		if rs.LargePRLines > 0 {
+			threshold = rs.LargePRLines
+		}
+		large = mergeModels(large, rs.LargeModels)
+		if rs.PromptsDir != "" {
+			out.AIPromptsDir = rs.PromptsDir
+		}
+		if rs.ReviewMode != "" {
+			out.AIReviewMode = strings.ToLower(rs.ReviewMode)
+		}
+	}
+	if threshold > 0 && changedLines >= threshold {
+		out.Models = mergeModels(out.Models, large)
+	}
+	return &out
+}
@@ -0,0 +1,209 @@
+package enrich
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"regexp"
+	"strings"
+)
+
+// goBlocks returns the top-level declarations of a Go file that contain a
+// change, with their doc comments. For methods the receiver's type
+// declaration is added too. ok is false when the file does not parse.
+func goBlocks(source string, changed []span) (blocks []span, ok bool) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", source, parser.ParseComments)
+	if err != nil {
+		return nil, false
+	}
+	line := func(p token.Pos) int { return fset.Position(p).Line }
+	declSpan := func(n ast.Node, doc *ast.CommentGroup) span {
+		s := span{line(n.Pos()), line(n.End())}
+		if doc != nil {
+			s.start = line(doc.Pos())
+		}
+		return s
+	}
+
+	types := map[string]span{}
+	var decls []span
+	var receivers []string
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			decls = append(decls, declSpan(d, d.Doc))
+			receivers = append(receivers, receiverType(d))
+		case *ast.GenDecl:
+			whole := declSpan(d, d.Doc)
+			if !d.Lparen.IsValid() {
+				decls = append(decls, whole)
+				receivers = append(receivers, "")
+			}
+			// A grouped declaration can be long; narrow it to its specs.
+			for _, spec := range d.Specs {
+				s := whole
+				if d.Lparen.IsValid() {
+					s = declSpan(spec, specDoc(spec))
+					decls = append(decls, s)
+					receivers = append(receivers, "")
+				}
+				if ts, ok := spec.(*ast.TypeSpec); ok {
+					types[ts.Name.Name] = s
+				}
+			}
+		}
+	}
+
+	for _, c := range changed {
+		for i, d := range decls {
+			if d.start > c.end || d.end < c.start {
+				continue
+			}
+			blocks = append(blocks, d)
+			if t, ok := types[receivers[i]]; ok && !t.contains(c) {
+				blocks = append(blocks, t)
+			}
+		}
+	}
+	return blocks, true
+}
+
+// receiverType returns the type name of a method's receiver, or "" for
+// plain functions.
+func receiverType(d *ast.FuncDecl) string {
+	if d.Recv == nil || len(d.Recv.List) == 0 {
+		return ""
+	}
+	expr := d.Recv.List[0].Type
+	for {
+		switch e := expr.(type) {
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.IndexExpr:
+			expr = e.X
+		case *ast.IndexListExpr:
+			expr = e.X
+		case *ast.Ident:
+			return e.Name
+		default:
+			return ""
+		}
+	}
+}
+
+func specDoc(spec ast.Spec) *ast.CommentGroup {
+	switch s := spec.(type) {
+	case *ast.TypeSpec:
+		return s.Doc
+	case *ast.ValueSpec:
+		return s.Doc
+	}
+	return nil
+}
+
+var (
+	// declPattern matches lines that start a function, method or type in
+	// common languages.
+	declPattern = regexp.MustCompile(`^\s*(@\w+\s+)*((export|default|pub(\([a-z]+\))?|public|private|protected|internal|static|final|abstract|override|virtual|async|unsafe|extern)\s+)*(func|function|def|fn|class|struct|interface|enum|trait|impl|module|object|record|sub|proc)\b`)
+	// methodPattern matches C-family method signatures such as
+	// "public int size() {" that have no declaring keyword.
+	methodPattern  = regexp.MustCompile(`^\s*[\w<>\[\],.?@ ]+\s\w+\s*\([^;]*$`)
+	controlPattern = regexp.MustCompile(`^\s*(if|else|for|foreach|while|do|switch|case|catch|try|return|with|elif|except|finally|await|new|throw|yield|go|defer|raise|assert)\b`)
+	closerPattern  = regexp.MustCompile(`^\s*([}\])]|end\b)`)
+)
+
+// indentBlocks finds the declaration enclosing each change by indentation:
+// the nearest less indented line above that looks like a declaration, or
+// the enclosing top-level line. The block ends before the next line that
+// is indented no deeper than its first line, or on it if it closes the
+// block.
+func indentBlocks(lines []string, changed []span) []span {
+	var blocks []span
+	for _, c := range changed {
+		first := c.start
+		for first <= c.end && first <= len(lines) && isBlank(lines[first-1]) {
+			first++
+		}
+		if first > len(lines) {
+			continue
+		}
+		start := 0
+		threshold := indentOf(lines[first-1])
+		if isDecl(lines[first-1]) {
+			// The change is to a declaration's first line.
+			start, threshold = first, 0
+		}
+		for n := first - 1; n >= 1 && threshold > 0; n-- {
+			text := lines[n-1]
+			if isBlank(text) || isComment(text) {
+				continue
+			}
+			ind := indentOf(text)
+			if ind >= threshold {
+				continue
+			}
+			threshold = ind
+			if ind == 0 || isDecl(text) {
+				start = n
+				break
+			}
+		}
+		if start == 0 {
+			continue
+		}
+
+		indent := indentOf(lines[start-1])
+		end, last := len(lines), start
+		for n := start + 1; n <= len(lines); n++ {
+			text := lines[n-1]
+			if isBlank(text) {
+				continue
+			}
+			if indentOf(text) <= indent {
+				end = last
+				if closerPattern.MatchString(text) {
+					end = n
+				}
+				break
+			}
+			last = n
+		}
+		if end < c.end {
+			end = c.end
+		}
+		blocks = append(blocks, span{start, end})
+	}
+	return blocks
+}
+
+func isDecl(text string) bool {
+	if declPattern.MatchString(text) {
+		return true
+	}
+	return methodPattern.MatchString(text) && !controlPattern.MatchString(text)
+}
+
+func isBlank(text string) bool { return strings.TrimSpace(text) == "" }
+
+func isComment(text string) bool {
+	t := strings.TrimSpace(text)
+	return strings.HasPrefix(t, "//") || strings.HasPrefix(t, "#") || strings.HasPrefix(t, "/*") || strings.HasPrefix(t, "*")
+}
+
+// indentOf returns the width of the leading whitespace, counting a tab as
+// four columns.
+func indentOf(text string) int {
+	width := 0
+	for _, r := range text {
+		switch r {
+		case ' ':
+			width++
+		case '\t':
+			width += 4
+		default:
+			return width
+		}
+	}
+	return width
+}
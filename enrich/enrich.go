@@ -0,0 +1,227 @@
+// Package enrich adds the code around each changed hunk to a pull request
+// diff. GitHub patches carry three lines of context, which rarely shows
+// which function a change is in or what the type it touches looks like.
+package enrich
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"codesage/ai"
+	"codesage/utils"
+)
+
+const (
+	// maxFiles caps how many files are fetched for one pull request.
+	maxFiles = 30
+	// fetchConcurrency is how many files are fetched at once.
+	fetchConcurrency = 4
+	// maxBlockLines is the longest declaration included whole. Longer ones
+	// are cut down to their first line, the lines around each change and
+	// their last line.
+	maxBlockLines = 120
+	// window is how many lines around a change are kept of a long
+	// declaration.
+	window = 25
+)
+
+// Fetcher returns the content of a file at the pull request's head.
+type Fetcher func(ctx context.Context, path string) (string, error)
+
+// span is a range of lines in the new version of a file, 1-based and
+// inclusive.
+type span struct{ start, end int }
+
+func (s span) contains(o span) bool { return s.start <= o.start && o.end <= s.end }
+
+// Enrich returns a copy of files with Context set to the enclosing
+// declarations of their hunks, spending at most budget tokens in total.
+// Files come first in the order given; a file whose context does not fit
+// or cannot be fetched is left without it.
+func Enrich(ctx context.Context, files []ai.FileDiff, fetch Fetcher, budget int) []ai.FileDiff {
+	out := make([]ai.FileDiff, len(files))
+	copy(out, files)
+	if budget <= 0 {
+		return out
+	}
+
+	// Added files are entirely in the patch and deleted ones have no head
+	// version, so neither is worth fetching.
+	var candidates []int
+	for i, f := range out {
+		header := firstLine(f.Patch)
+		if f.Patch != "" && !strings.HasPrefix(header, "@@ -0,0 ") && !strings.Contains(header, " +0,0 @@") {
+			candidates = append(candidates, i)
+		}
+		if len(candidates) == maxFiles {
+			break
+		}
+	}
+
+	sources := make([]string, len(out))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, fetchConcurrency)
+	for _, i := range candidates {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			source, err := fetch(ctx, out[i].Path)
+			if err != nil {
+				utils.Errorf("could not fetch %s for context: %v", out[i].Path, err)
+				return
+			}
+			sources[i] = source
+		}(i)
+	}
+	wg.Wait()
+
+	used, enriched := 0, 0
+	for _, i := range candidates {
+		if sources[i] == "" {
+			continue
+		}
+		text := fileContext(out[i].Path, sources[i], out[i].Patch)
+		cost := ai.EstimateTokens(text)
+		if text == "" || used+cost > budget {
+			continue
+		}
+		out[i].Context = text
+		used += cost
+		enriched++
+	}
+	if enriched > 0 {
+		utils.Infof("added surrounding code to %d of %d files (~%d tokens)", enriched, len(files), used)
+	}
+	return out
+}
+
+// fileContext renders the enclosing declarations of the patch's hunks in
+// source, skipping those the patch already shows in full.
+func fileContext(path, source, patch string) string {
+	diff := ai.ParsePatch(patch)
+	changed := changedSpans(diff)
+	if len(changed) == 0 {
+		return ""
+	}
+	visible := map[int]bool{}
+	for _, l := range diff {
+		if l.Kind != '-' {
+			visible[l.NewLine] = true
+		}
+	}
+	lines := strings.Split(strings.TrimSuffix(source, "\n"), "\n")
+
+	var blocks []span
+	if strings.HasSuffix(path, ".go") {
+		var ok bool
+		if blocks, ok = goBlocks(source, changed); !ok {
+			blocks = indentBlocks(lines, changed)
+		}
+	} else {
+		blocks = indentBlocks(lines, changed)
+	}
+
+	var keep []span
+	for _, block := range mergeSpans(blocks) {
+		if block.end > len(lines) {
+			block.end = len(lines)
+		}
+		if block.start < 1 || block.start > block.end || allVisible(block, visible) {
+			continue
+		}
+		if block.end-block.start+1 <= maxBlockLines {
+			keep = append(keep, block)
+			continue
+		}
+		keep = append(keep, span{block.start, block.start}, span{block.end, block.end})
+		for _, c := range changed {
+			if c.end >= block.start && c.start <= block.end {
+				keep = append(keep, span{max(block.start, c.start-window), min(block.end, c.end+window)})
+			}
+		}
+	}
+	return render(lines, mergeSpans(keep))
+}
+
+// changedSpans returns the new-file lines each hunk changes. A removed line
+// counts as a change to the line that now follows it.
+func changedSpans(diff []ai.DiffLine) []span {
+	var at []int
+	next := 0
+	for i := len(diff) - 1; i >= 0; i-- {
+		l := diff[i]
+		switch {
+		case l.Kind != '-':
+			next = l.NewLine
+			if l.Kind == '+' {
+				at = append(at, l.NewLine)
+			}
+		case next > 0:
+			at = append(at, next)
+		}
+	}
+	sort.Ints(at)
+	var spans []span
+	for _, n := range at {
+		if len(spans) > 0 && n <= spans[len(spans)-1].end+3 {
+			spans[len(spans)-1].end = max(spans[len(spans)-1].end, n)
+			continue
+		}
+		spans = append(spans, span{n, n})
+	}
+	return spans
+}
+
+// mergeSpans sorts spans and joins those that overlap or touch.
+func mergeSpans(spans []span) []span {
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	var out []span
+	for _, s := range spans {
+		if n := len(out); n > 0 && s.start <= out[n-1].end+1 {
+			out[n-1].end = max(out[n-1].end, s.end)
+			continue
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+func allVisible(s span, visible map[int]bool) bool {
+	for n := s.start; n <= s.end; n++ {
+		if !visible[n] {
+			return false
+		}
+	}
+	return true
+}
+
+// render prints the spans of lines with their line numbers, marking the
+// lines left out between them.
+func render(lines []string, spans []span) string {
+	var b strings.Builder
+	for i, s := range spans {
+		from := s.start
+		if i > 0 {
+			switch gap := s.start - spans[i-1].end - 1; {
+			case gap == 1:
+				from-- // a marker would take as much room as the line
+			case gap > 1:
+				b.WriteString("      …\n")
+			}
+		}
+		for n := from; n <= s.end; n++ {
+			fmt.Fprintf(&b, "%5d  %s\n", n, lines[n-1])
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
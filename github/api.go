@@ -2,12 +2,9 @@
 package github
 
 import (
-    "bytes"
-    "encoding/json"
+    "context"
     "fmt"
-    "io"
-    "net/http"
-    "codesage/config"
+    "strings"
 )
 
 // GitHub API structures
@@ -15,75 +12,143 @@ type PullRequestFiles struct {
     Filename string `json:"filename"`
     Patch    string `json:"patch"`
     Status   string `json:"status"`
+    // Changes counts added plus deleted lines; binary files have none
+    Changes int `json:"changes"`
 }
 
 type CommentRequest struct {
     Body string `json:"body"`
 }
 
-// GetPRFiles fetches the file changes for a pull request
-func GetPRFiles(owner, repo string, prNumber int, cfg *config.Config) ([]PullRequestFiles, error) {
-    url := fmt.Sprintf("https://api.github.com/repos/%s/%s/pulls/%d/files", owner, repo, prNumber)
-    
-    req, err := http.NewRequest("GET", url, nil)
-    if err != nil {
-        return nil, err
+type IssueComment struct {
+    ID      int64  `json:"id"`
+    HTMLURL string `json:"html_url"`
+}
+
+// maxPRFiles is the most files the PR files endpoint returns
+const maxPRFiles = 3000
+
+// GetPRFiles fetches the file changes for a pull request, following
+// pagination up to GitHub's 3000-file cap. Patches GitHub leaves out of the
+// listing because they are too large are filled in from the PR's diff.
+func (c *Client) GetPRFiles(ctx context.Context, owner, repo string, prNumber int) ([]PullRequestFiles, error) {
+    var files []PullRequestFiles
+    next := fmt.Sprintf("/repos/%s/%s/pulls/%d/files?per_page=100", owner, repo, prNumber)
+    for next != "" && len(files) < maxPRFiles {
+        var page []PullRequestFiles
+        resp, err := c.do(ctx, request{method: "GET", path: next}, &page)
+        if err != nil {
+            return nil, err
+        }
+        files = append(files, page...)
+        next = nextPage(resp)
+    }
+    if len(files) >= maxPRFiles {
+        fmt.Printf("⚠️ PR #%d has at least %d files; only the first %d are listed\n", prNumber, maxPRFiles, maxPRFiles)
     }
     
-    // Prefer installation token if available (set by caller via context of cfg.GitHubToken)
-    req.Header.Set("Authorization", "Bearer "+cfg.GitHubToken)
-    req.Header.Set("Accept", "application/vnd.github.v3+json")
-    
-    client := &http.Client{}
-    resp, err := client.Do(req)
-    if err != nil {
-        return nil, err
+    missing := 0
+    for _, f := range files {
+        if f.Patch == "" && f.Changes > 0 {
+            missing++
+        }
+    }
+    if missing == 0 {
+        return files, nil
     }
-    defer resp.Body.Close()
     
-    body, err := io.ReadAll(resp.Body)
+    // Binary files have no changed lines, so these are text patches GitHub
+    // considered too large for the listing
+    diff, err := c.GetPRDiff(ctx, owner, repo, prNumber)
     if err != nil {
-        return nil, err
+        fmt.Printf("⚠️ %d file(s) have no patch and the PR diff is unavailable: %v\n", missing, err)
+        return files, nil
     }
-    
-    var files []PullRequestFiles
-    if err := json.Unmarshal(body, &files); err != nil {
-        return nil, err
+    patches := SplitDiff(diff)
+    for i, f := range files {
+        if f.Patch == "" && f.Changes > 0 {
+            files[i].Patch = patches[f.Filename]
+        }
     }
-    
     return files, nil
 }
 
-// PostComment posts a comment on a pull request
-func PostComment(owner, repo string, prNumber int, comment string, cfg *config.Config) error {
-    url := fmt.Sprintf("https://api.github.com/repos/%s/%s/issues/%d/comments", owner, repo, prNumber)
-    
-    commentReq := CommentRequest{Body: comment}
-    jsonData, err := json.Marshal(commentReq)
-    if err != nil {
-        return err
+// GetPRDiff fetches the whole pull request as a unified diff. GitHub refuses
+// diffs that are too large, e.g. over 300 files.
+func (c *Client) GetPRDiff(ctx context.Context, owner, repo string, prNumber int) (string, error) {
+    var diff []byte
+    path := fmt.Sprintf("/repos/%s/%s/pulls/%d", owner, repo, prNumber)
+    if _, err := c.do(ctx, request{method: "GET", path: path, accept: "application/vnd.github.diff"}, &diff); err != nil {
+        return "", err
     }
-    
-    req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-    if err != nil {
-        return err
+    return string(diff), nil
+}
+
+// SplitDiff splits a unified git diff into per-file patches, keyed by the
+// file's new path (its old path for deleted files). Like the patches in the
+// PR files listing, each starts at its first hunk header.
+func SplitDiff(diff string) map[string]string {
+    patches := map[string]string{}
+    var path string
+    var patch strings.Builder
+    flush := func() {
+        if path != "" && patch.Len() > 0 {
+            patches[path] = strings.TrimRight(patch.String(), "\n")
+        }
+        path = ""
+        patch.Reset()
     }
-    
-    req.Header.Set("Authorization", "Bearer "+cfg.GitHubToken)
-    req.Header.Set("Accept", "application/vnd.github.v3+json")
-    req.Header.Set("Content-Type", "application/json")
-    
-    client := &http.Client{}
-    resp, err := client.Do(req)
-    if err != nil {
-        return err
+    inHunks := false
+    for _, line := range strings.Split(diff, "\n") {
+        switch {
+        case strings.HasPrefix(line, "diff --git "):
+            flush()
+            inHunks = false
+            // Fallback for diffs without ---/+++ lines, e.g. pure renames
+            if _, b, ok := strings.Cut(line, " b/"); ok {
+                path = b
+            }
+        case !inHunks && strings.HasPrefix(line, "--- a/"):
+            path = strings.TrimPrefix(line, "--- a/")
+        case !inHunks && strings.HasPrefix(line, "+++ b/"):
+            path = strings.TrimPrefix(line, "+++ b/")
+        case strings.HasPrefix(line, "@@"):
+            inHunks = true
+            patch.WriteString(line + "\n")
+        case inHunks:
+            patch.WriteString(line + "\n")
+        }
     }
-    defer resp.Body.Close()
-    
-    if resp.StatusCode != http.StatusCreated {
-        body, _ := io.ReadAll(resp.Body)
-        return fmt.Errorf("failed to post comment: %s", string(body))
+    flush()
+    return patches
+}
+
+// PostComment posts a comment on a pull request
+func (c *Client) PostComment(ctx context.Context, owner, repo string, prNumber int, comment string) error {
+    _, err := c.CreateComment(ctx, owner, repo, prNumber, comment)
+    return err
+}
+
+// CreateComment posts a comment on a pull request and returns its ID
+func (c *Client) CreateComment(ctx context.Context, owner, repo string, prNumber int, comment string) (int64, error) {
+    var created IssueComment
+    path := fmt.Sprintf("/repos/%s/%s/issues/%d/comments", owner, repo, prNumber)
+    if _, err := c.do(ctx, request{method: "POST", path: path, body: CommentRequest{Body: comment}}, &created); err != nil {
+        return 0, err
     }
-    
-    return nil
-}
\ No newline at end of file
+    return created.ID, nil
+}
+
+// EditComment replaces the body of an existing comment
+func (c *Client) EditComment(ctx context.Context, owner, repo string, commentID int64, comment string) error {
+    path := fmt.Sprintf("/repos/%s/%s/issues/comments/%d", owner, repo, commentID)
+    _, err := c.do(ctx, request{method: "PATCH", path: path, body: CommentRequest{Body: comment}}, nil)
+    return err
+}
+
+// DeleteComment deletes a comment
+func (c *Client) DeleteComment(ctx context.Context, owner, repo string, commentID int64) error {
+    path := fmt.Sprintf("/repos/%s/%s/issues/comments/%d", owner, repo, commentID)
+    _, err := c.do(ctx, request{method: "DELETE", path: path}, nil)
+    return err
+}
@@ -0,0 +1,47 @@
+package github
+
+import "testing"
+
+func TestSplitDiff(t *testing.T) {
+	diff := `diff --git a/main.go b/main.go
+index 1111111..2222222 100644
+--- a/main.go
++++ b/main.go
+@@ -1,2 +1,2 @@
+ package main
+-// old
++// new
+diff --git a/gone.txt b/gone.txt
+deleted file mode 100644
+--- a/gone.txt
++++ /dev/null
+@@ -1 +0,0 @@
+-bye
+diff --git a/old.go b/renamed.go
+similarity index 100%
+rename from old.go
+rename to renamed.go
+diff --git a/img.png b/img.png
+Binary files a/img.png and b/img.png differ
+diff --git a/new.go b/new.go
+new file mode 100644
+--- /dev/null
++++ b/new.go
+@@ -0,0 +1 @@
++package x
+`
+	got := SplitDiff(diff)
+	want := map[string]string{
+		"main.go":  "@@ -1,2 +1,2 @@\n package main\n-// old\n+// new",
+		"gone.txt": "@@ -1 +0,0 @@\n-bye",
+		"new.go":   "@@ -0,0 +1 @@\n+package x",
+	}
+	if len(got) != len(want) {
+		t.Errorf("SplitDiff returned %d patches, want %d: %v", len(got), len(want), got)
+	}
+	for path, patch := range want {
+		if got[path] != patch {
+			t.Errorf("%s:\n%q\nwant\n%q", path, got[path], patch)
+		}
+	}
+}
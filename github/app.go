@@ -1,12 +1,11 @@
 package github
 
 import (
+    "context"
     "crypto/hmac"
     "crypto/sha256"
     "encoding/hex"
-    "encoding/json"
     "fmt"
-    "net/http"
     "strings"
     "time"
     "codesage/config"
@@ -58,33 +57,30 @@ type installationTokenResponse struct {
 }
 
 // GetInstallationToken exchanges an App JWT for an installation access token
-func GetInstallationToken(cfg *config.Config, installationID int64) (string, time.Time, error) {
+func GetInstallationToken(ctx context.Context, cfg *config.Config, installationID int64) (string, time.Time, error) {
     appJWT, err := generateAppJWT(cfg)
     if err != nil {
         return "", time.Time{}, err
     }
-    url := fmt.Sprintf("https://api.github.com/app/installations/%d/access_tokens", installationID)
-    req, err := http.NewRequest("POST", url, nil)
-    if err != nil {
-        return "", time.Time{}, err
-    }
-    req.Header.Set("Authorization", "Bearer "+appJWT)
-    req.Header.Set("Accept", "application/vnd.github+json")
-    client := &http.Client{}
-    resp, err := client.Do(req)
-    if err != nil {
-        return "", time.Time{}, err
-    }
-    defer resp.Body.Close()
-    if resp.StatusCode != http.StatusCreated {
-        return "", time.Time{}, fmt.Errorf("failed to get installation token: %s", resp.Status)
-    }
     var out installationTokenResponse
-    if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-        return "", time.Time{}, err
+    path := fmt.Sprintf("/app/installations/%d/access_tokens", installationID)
+    if _, err := NewClient(cfg.GitHubAPIURL, appJWT).do(ctx, request{method: "POST", path: path}, &out); err != nil {
+        return "", time.Time{}, fmt.Errorf("failed to get installation token: %w", err)
     }
     return out.Token, out.ExpiresAt, nil
 }
 
-
-
+// installationClient returns a client authenticated as the installation,
+// or with the configured token when installationID is 0 (webhooks from a
+// repository rather than an App)
+func installationClient(ctx context.Context, cfg *config.Config, installationID int64) (*Client, error) {
+    client := ClientFromConfig(cfg)
+    if installationID == 0 {
+        return client, nil
+    }
+    token, err := tokenCacheFor(cfg).Token(ctx, installationID)
+    if err != nil {
+        return nil, err
+    }
+    return client.WithToken(token), nil
+}
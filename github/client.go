@@ -0,0 +1,187 @@
+package github
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"codesage/config"
+)
+
+const (
+	// DefaultBaseURL is the REST API of github.com. GitHub Enterprise Server
+	// serves it under https://<host>/api/v3.
+	DefaultBaseURL = "https://api.github.com"
+	userAgent      = "CodeSage"
+	// requestTimeout bounds a single API call, including reading the body.
+	requestTimeout = 30 * time.Second
+)
+
+// sharedTransport is used by every Client so connections to GitHub are
+// pooled across webhooks.
+var sharedTransport = &http.Transport{
+	Proxy: http.ProxyFromEnvironment,
+	DialContext: (&net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext,
+	ForceAttemptHTTP2:     true,
+	MaxIdleConns:          100,
+	MaxIdleConnsPerHost:   20,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ExpectContinueTimeout: time.Second,
+}
+
+// Client calls the GitHub REST API with one set of credentials. It is
+// safe for concurrent use.
+type Client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+// NewClient returns a client for the API at baseURL, or github.com when it
+// is empty, authenticated with token.
+func NewClient(baseURL, token string) *Client {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	return &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		token:   token,
+		http:    &http.Client{Transport: sharedTransport, Timeout: requestTimeout},
+	}
+}
+
+// ClientFromConfig returns a client for the configured API URL and token.
+func ClientFromConfig(cfg *config.Config) *Client {
+	return NewClient(cfg.GitHubAPIURL, cfg.GitHubToken)
+}
+
+// WithToken returns a copy of c that authenticates with token.
+func (c *Client) WithToken(token string) *Client {
+	out := *c
+	out.token = token
+	return &out
+}
+
+// APIError is a non-2xx response from GitHub.
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	// Message is GitHub's error message, or the raw body when it is not
+	// JSON.
+	Message          string
+	DocumentationURL string
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("GitHub API %s %s: %d %s", e.Method, e.Path, e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// NotFound reports whether the resource does not exist or is not visible
+// with the client's credentials.
+func (e *APIError) NotFound() bool { return e.StatusCode == http.StatusNotFound }
+
+// request describes one API call.
+type request struct {
+	method string
+	// path is relative to the base URL and may include a query string.
+	// Absolute URLs under the base URL, as found in Link headers, are
+	// accepted too.
+	path   string
+	accept string
+	body   any
+}
+
+// do sends r and decodes a JSON response into out, if out is not nil, or
+// stores the raw body when out is a *[]byte. The response is returned for
+// its headers; its body is already closed.
+func (c *Client) do(ctx context.Context, r request, out any) (*http.Response, error) {
+	var body io.Reader
+	if r.body != nil {
+		data, err := json.Marshal(r.body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request: %v", err)
+		}
+		body = bytes.NewReader(data)
+	}
+	url := r.path
+	if !strings.HasPrefix(url, c.baseURL+"/") {
+		url = c.baseURL + r.path
+	}
+	req, err := http.NewRequestWithContext(ctx, r.method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	accept := r.accept
+	if accept == "" {
+		accept = "application/vnd.github+json"
+	}
+	req.Header.Set("Accept", accept)
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		apiErr := &APIError{Method: r.method, Path: req.URL.Path, StatusCode: resp.StatusCode}
+		var ghErr struct {
+			Message          string `json:"message"`
+			DocumentationURL string `json:"documentation_url"`
+		}
+		if json.Unmarshal(data, &ghErr) == nil && ghErr.Message != "" {
+			apiErr.Message, apiErr.DocumentationURL = ghErr.Message, ghErr.DocumentationURL
+		} else {
+			apiErr.Message = strings.TrimSpace(string(data))
+		}
+		return resp, apiErr
+	}
+	if raw, ok := out.(*[]byte); ok {
+		if *raw, err = io.ReadAll(resp.Body); err != nil {
+			return resp, fmt.Errorf("failed to read GitHub response: %v", err)
+		}
+		return resp, nil
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp, fmt.Errorf("failed to decode GitHub response: %v", err)
+		}
+	}
+	return resp, nil
+}
+
+// nextPage returns the URL of the next page from a Link header, or "" on
+// the last page.
+func nextPage(resp *http.Response) string {
+	for _, link := range strings.Split(resp.Header.Get("Link"), ",") {
+		url, params, ok := strings.Cut(link, ";")
+		if ok && strings.Contains(params, `rel="next"`) {
+			return strings.Trim(strings.TrimSpace(url), "<>")
+		}
+	}
+	return ""
+}
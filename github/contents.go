@@ -0,0 +1,116 @@
+package github
+
+import (
+    "context"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "net/url"
+    "strings"
+)
+
+// ContentEntry is a file or directory returned by the contents API
+type ContentEntry struct {
+    Type     string `json:"type"`
+    Name     string `json:"name"`
+    Path     string `json:"path"`
+    Size     int    `json:"size"`
+    Encoding string `json:"encoding"`
+    Content  string `json:"content"`
+}
+
+// CodeSearchResult is a file matching a code search
+type CodeSearchResult struct {
+    Path        string `json:"path"`
+    TextMatches []struct {
+        Fragment string `json:"fragment"`
+    } `json:"text_matches"`
+}
+
+// PRCommit is a commit belonging to a pull request
+type PRCommit struct {
+    SHA    string `json:"sha"`
+    Commit struct {
+        Message string `json:"message"`
+        Author  struct {
+            Name string `json:"name"`
+            Date string `json:"date"`
+        } `json:"author"`
+    } `json:"commit"`
+}
+
+// contentsPath builds the contents API path for path at ref
+func contentsPath(owner, repo, path, ref string) string {
+    segments := strings.Split(strings.Trim(path, "/"), "/")
+    for i, s := range segments {
+        segments[i] = url.PathEscape(s)
+    }
+    u := fmt.Sprintf("/repos/%s/%s/contents/%s", owner, repo, strings.Join(segments, "/"))
+    if ref != "" {
+        u += "?ref=" + url.QueryEscape(ref)
+    }
+    return u
+}
+
+// GetFileContent returns the content of a file at ref (a branch, tag or commit SHA)
+func (c *Client) GetFileContent(ctx context.Context, owner, repo, path, ref string) (string, error) {
+    var entry ContentEntry
+    if _, err := c.do(ctx, request{method: "GET", path: contentsPath(owner, repo, path, ref)}, &entry); err != nil {
+        return "", err
+    }
+    if entry.Type != "file" {
+        return "", fmt.Errorf("%s is a %s, not a file", path, entry.Type)
+    }
+    // Files over 1 MB come back without content
+    if entry.Encoding != "base64" {
+        return "", fmt.Errorf("%s is too large to fetch (%d bytes)", path, entry.Size)
+    }
+    data, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(entry.Content, "\n", ""))
+    if err != nil {
+        return "", fmt.Errorf("failed to decode %s: %v", path, err)
+    }
+    return string(data), nil
+}
+
+// ListDir lists a directory at ref; an empty path is the repository root
+func (c *Client) ListDir(ctx context.Context, owner, repo, path, ref string) ([]ContentEntry, error) {
+    var raw json.RawMessage
+    if _, err := c.do(ctx, request{method: "GET", path: contentsPath(owner, repo, path, ref)}, &raw); err != nil {
+        return nil, err
+    }
+    // A file path returns an object instead of a list
+    if !strings.HasPrefix(strings.TrimSpace(string(raw)), "[") {
+        return nil, fmt.Errorf("%s is not a directory", path)
+    }
+    var entries []ContentEntry
+    if err := json.Unmarshal(raw, &entries); err != nil {
+        return nil, err
+    }
+    return entries, nil
+}
+
+// SearchCode searches the repository's default branch for query
+func (c *Client) SearchCode(ctx context.Context, owner, repo, query string) ([]CodeSearchResult, error) {
+    q := url.QueryEscape(fmt.Sprintf("%s repo:%s/%s", query, owner, repo))
+
+    var result struct {
+        Items []CodeSearchResult `json:"items"`
+    }
+    // The text-match media type adds the matching fragments
+    r := request{method: "GET", path: "/search/code?per_page=20&q=" + q, accept: "application/vnd.github.text-match+json"}
+    if _, err := c.do(ctx, r, &result); err != nil {
+        return nil, err
+    }
+    return result.Items, nil
+}
+
+// GetPRCommits lists the commits of a pull request, oldest first
+func (c *Client) GetPRCommits(ctx context.Context, owner, repo string, prNumber int) ([]PRCommit, error) {
+    path := fmt.Sprintf("/repos/%s/%s/pulls/%d/commits?per_page=100", owner, repo, prNumber)
+
+    var commits []PRCommit
+    if _, err := c.do(ctx, request{method: "GET", path: path}, &commits); err != nil {
+        return nil, err
+    }
+    return commits, nil
+}
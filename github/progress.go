@@ -0,0 +1,99 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"codesage/ai"
+)
+
+// reviewHeading starts every CodeSage review comment.
+const reviewHeading = "## 🤖 CodeSage AI Review"
+
+// commentStream keeps a placeholder PR comment up to date while a review
+// streams in. Updates are rate limited to one edit per interval, because
+// every edit is a GitHub API call and notifies nobody anyway.
+type commentStream struct {
+	ctx         context.Context
+	client      *Client
+	owner, repo string
+	id          int64
+
+	mu     sync.Mutex
+	latest string
+	posted string
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// startCommentStream posts the placeholder comment and starts editing it.
+// Edits outlive ctx's deadline so the final one is made even when the
+// review ran out of time.
+func startCommentStream(ctx context.Context, client *Client, owner, repo string, prNumber int, interval time.Duration) (*commentStream, error) {
+	body := fmt.Sprintf("%s\n\n%s", reviewHeading, ai.RenderProgress(ai.Progress{}))
+	id, err := client.CreateComment(ctx, owner, repo, prNumber, body)
+	if err != nil {
+		return nil, err
+	}
+	s := &commentStream{
+		ctx: context.WithoutCancel(ctx), client: client,
+		owner: owner, repo: repo, id: id,
+		latest: body, posted: body,
+		stop: make(chan struct{}), done: make(chan struct{}),
+	}
+	if interval <= 0 {
+		interval = 3 * time.Second
+	}
+	go s.run(interval)
+	return s, nil
+}
+
+// Update records the latest progress; it is safe for concurrent use.
+func (s *commentStream) Update(p ai.Progress) {
+	body := fmt.Sprintf("%s\n\n%s", reviewHeading, ai.RenderProgress(p))
+	s.mu.Lock()
+	s.latest = body
+	s.mu.Unlock()
+}
+
+func (s *commentStream) run(interval time.Duration) {
+	defer close(s.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			body := s.latest
+			s.mu.Unlock()
+			if body == s.posted {
+				continue
+			}
+			if err := s.client.EditComment(s.ctx, s.owner, s.repo, s.id, body); err != nil {
+				fmt.Printf("⚠️ Failed to update progress comment: %v\n", err)
+				continue
+			}
+			s.posted = body
+		}
+	}
+}
+
+// Finish stops the updates and replaces the placeholder with body.
+func (s *commentStream) Finish(body string) error {
+	close(s.stop)
+	<-s.done
+	return s.client.EditComment(s.ctx, s.owner, s.repo, s.id, body)
+}
+
+// Discard stops the updates and deletes the placeholder, for when the
+// review is posted some other way.
+func (s *commentStream) Discard() error {
+	close(s.stop)
+	<-s.done
+	return s.client.DeleteComment(s.ctx, s.owner, s.repo, s.id)
+}
@@ -0,0 +1,142 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"codesage/ai"
+)
+
+// maxInlineComments caps the comments of one review; further findings are
+// listed in the review body.
+const maxInlineComments = 50
+
+// ReviewComment is a review comment on a line, or a range of lines, of the
+// new version of a file.
+type ReviewComment struct {
+	Path      string `json:"path"`
+	Body      string `json:"body"`
+	Line      int    `json:"line"`
+	Side      string `json:"side"`
+	StartLine int    `json:"start_line,omitempty"`
+	StartSide string `json:"start_side,omitempty"`
+}
+
+// NewReview is the payload that creates a pull request review.
+type NewReview struct {
+	// CommitID is the commit the comments' lines refer to.
+	CommitID string          `json:"commit_id,omitempty"`
+	Body     string          `json:"body"`
+	Event    string          `json:"event"`
+	Comments []ReviewComment `json:"comments,omitempty"`
+}
+
+// PullRequestReview is a created review.
+type PullRequestReview struct {
+	ID      int64  `json:"id"`
+	HTMLURL string `json:"html_url"`
+}
+
+// CreateReview submits a pull request review with its comments.
+func (c *Client) CreateReview(ctx context.Context, owner, repo string, prNumber int, review NewReview) (*PullRequestReview, error) {
+	var created PullRequestReview
+	path := fmt.Sprintf("/repos/%s/%s/pulls/%d/reviews", owner, repo, prNumber)
+	if _, err := c.do(ctx, request{method: "POST", path: path, body: review}, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// diffLines indexes, per file, the new-file lines a review comment can be
+// attached to (added and context lines) with the hunk each belongs to.
+type diffLines map[string]map[int]int
+
+func indexDiff(files []ai.FileDiff) diffLines {
+	index := diffLines{}
+	for _, f := range files {
+		lines := map[int]int{}
+		hunk, prev := 0, 0
+		for _, l := range ai.ParsePatch(f.Patch) {
+			if l.Kind == '-' {
+				continue
+			}
+			// Hunks are always separated by unchanged lines outside the patch.
+			if l.NewLine != prev+1 {
+				hunk++
+			}
+			lines[l.NewLine] = hunk
+			prev = l.NewLine
+		}
+		index[f.Path] = lines
+	}
+	return index
+}
+
+// anchor places a comment for f on the diff: on its whole range when both
+// ends are in the same hunk, otherwise on the first of its lines the diff
+// shows. ok is false when none is.
+func (d diffLines) anchor(f ai.Finding) (ReviewComment, bool) {
+	lines := d[f.File]
+	start, end := f.StartLine, max(f.EndLine, f.StartLine)
+	c := ReviewComment{Path: f.File, Side: "RIGHT", Body: ai.RenderInline(f)}
+	hs, okStart := lines[start]
+	he, okEnd := lines[end]
+	if okStart && okEnd && hs == he {
+		c.Line = end
+		if start < end {
+			c.StartLine, c.StartSide = start, "RIGHT"
+		}
+		return c, true
+	}
+	for n := start; n <= end; n++ {
+		if _, ok := lines[n]; ok {
+			c.Line = n
+			return c, true
+		}
+	}
+	return c, false
+}
+
+// inlineReview builds a review of the findings. Each finding on a line of
+// the diff becomes a comment there, up to maxInlineComments; the others are
+// listed in the body under the summary, AI findings and static checks
+// separately.
+func inlineReview(files []ai.FileDiff, headSHA, summary string, findings, static []ai.Finding, footer string) NewReview {
+	index := indexDiff(files)
+	review := NewReview{CommitID: headSHA, Event: "COMMENT"}
+	place := func(all []ai.Finding) (rest []ai.Finding) {
+		for _, f := range all {
+			if len(review.Comments) < maxInlineComments {
+				if c, ok := index.anchor(f); ok {
+					review.Comments = append(review.Comments, c)
+					continue
+				}
+			}
+			rest = append(rest, f)
+		}
+		return rest
+	}
+	restAI, restStatic := place(findings), place(static)
+
+	var b strings.Builder
+	b.WriteString(reviewHeading + "\n\n")
+	if summary != "" {
+		b.WriteString(summary + "\n")
+	}
+	switch n := len(review.Comments); {
+	case n > 0:
+		fmt.Fprintf(&b, "\n%d finding(s) are commented on the changed lines.\n", n)
+	case len(restAI) == 0 && len(restStatic) == 0:
+		b.WriteString("\nNo issues found. 🎉\n")
+	}
+	if len(restAI) > 0 {
+		b.WriteString(ai.RenderFindings("Other findings", restAI))
+	}
+	if len(restStatic) > 0 {
+		b.WriteString(ai.RenderFindings("Static checks", restStatic))
+	}
+	b.WriteString("\n" + footer)
+	review.Body = b.String()
+	return review
+}
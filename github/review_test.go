@@ -0,0 +1,90 @@
+package github
+
+import (
+	"strings"
+	"testing"
+
+	"codesage/ai"
+)
+
+// reviewFiles has two hunks in a.go: new lines 1-4 and 21-22.
+var reviewFiles = []ai.FileDiff{{
+	Path:  "a.go",
+	Patch: "@@ -1,3 +1,4 @@\n x\n+y\n z\n+w\n@@ -20,2 +21,2 @@\n q\n-r\n+s",
+}}
+
+func TestAnchor(t *testing.T) {
+	index := indexDiff(reviewFiles)
+	tests := []struct {
+		name       string
+		file       string
+		start, end int
+		ok         bool
+		startLine  int
+		line       int
+	}{
+		{"single added line", "a.go", 2, 2, true, 0, 2},
+		{"context line", "a.go", 3, 0, true, 0, 3},
+		{"range in one hunk", "a.go", 2, 4, true, 2, 4},
+		{"range across hunks", "a.go", 3, 22, true, 0, 3},
+		{"range starting before the hunk", "a.go", 18, 21, true, 0, 21},
+		{"between hunks", "a.go", 10, 12, false, 0, 0},
+		{"removed line only", "a.go", 23, 23, false, 0, 0},
+		{"file not in diff", "b.go", 1, 1, false, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, ok := index.anchor(ai.Finding{File: tt.file, StartLine: tt.start, EndLine: tt.end, Message: "m"})
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if c.Line != tt.line || c.StartLine != tt.startLine || c.Side != "RIGHT" {
+				t.Errorf("comment = %+v, want lines %d-%d on the right side", c, tt.startLine, tt.line)
+			}
+			if (c.StartLine != 0) != (c.StartSide == "RIGHT") {
+				t.Errorf("start_side = %q with start_line %d", c.StartSide, c.StartLine)
+			}
+		})
+	}
+}
+
+func TestInlineReview(t *testing.T) {
+	findings := []ai.Finding{
+		{File: "a.go", StartLine: 2, EndLine: 2, Severity: ai.SeverityMajor, Category: "bug", Message: "on the diff"},
+		{File: "a.go", StartLine: 10, EndLine: 10, Severity: ai.SeverityMinor, Category: "style", Message: "off the diff"},
+	}
+	static := []ai.Finding{{File: "a.go", StartLine: 4, EndLine: 4, Severity: ai.SeverityInfo, Category: "maintainability", Rule: "todo", Message: "static"}}
+	review := inlineReview(reviewFiles, "abc123", "Summary.", findings, static, "footer")
+
+	if review.CommitID != "abc123" || review.Event != "COMMENT" {
+		t.Errorf("review = %+v", review)
+	}
+	if len(review.Comments) != 2 || review.Comments[0].Line != 2 || review.Comments[1].Line != 4 {
+		t.Errorf("comments = %+v, want lines 2 and 4", review.Comments)
+	}
+	for _, want := range []string{"Summary.", "2 finding(s)", "Other findings", "off the diff", "footer"} {
+		if !strings.Contains(review.Body, want) {
+			t.Errorf("body missing %q:\n%s", want, review.Body)
+		}
+	}
+	if strings.Contains(review.Body, "on the diff") || strings.Contains(review.Body, "Static checks") {
+		t.Errorf("body repeats inline findings:\n%s", review.Body)
+	}
+}
+
+func TestInlineReviewCap(t *testing.T) {
+	var findings []ai.Finding
+	for i := 0; i < maxInlineComments+5; i++ {
+		findings = append(findings, ai.Finding{File: "a.go", StartLine: 2, Severity: ai.SeverityMinor, Category: "style", Message: "m"})
+	}
+	review := inlineReview(reviewFiles, "abc123", "", findings, nil, "")
+	if len(review.Comments) != maxInlineComments {
+		t.Errorf("%d comments, want %d", len(review.Comments), maxInlineComments)
+	}
+	if got := strings.Count(review.Body, "`a.go:2`"); got != 5 {
+		t.Errorf("body lists %d findings past the cap, want 5", got)
+	}
+}
@@ -0,0 +1,112 @@
+package github
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"codesage/config"
+)
+
+// minTokenRefreshMargin is the least time before expiry at which a cached
+// installation token is replaced.
+const minTokenRefreshMargin = 15 * time.Minute
+
+// tokenRefreshMargin is how long before expiry a cached installation token
+// is replaced. It exceeds the review timeout, with time left to post the
+// results, so a token handed out at the start of a review stays valid
+// until its last API call.
+func tokenRefreshMargin(reviewTimeout time.Duration) time.Duration {
+	return max(minTokenRefreshMargin, reviewTimeout+5*time.Minute)
+}
+
+// TokenCache hands out installation access tokens, minting one only when
+// the installation has none that stays valid for the refresh margin.
+// Concurrent requests for the same installation share a single mint. It is
+// safe for concurrent use.
+type TokenCache struct {
+	mint func(ctx context.Context, installationID int64) (string, time.Time, error)
+	now  func() time.Time
+	// margin is how long a token handed out must stay valid.
+	margin time.Duration
+
+	mu     sync.Mutex
+	tokens map[int64]cachedToken
+	// gens counts evictions per installation, so a mint that was already
+	// in flight does not re-cache a token after Evict.
+	gens   map[int64]uint64
+	flight singleflight.Group
+}
+
+type cachedToken struct {
+	token     string
+	expiresAt time.Time
+}
+
+// NewTokenCache returns a cache that mints tokens with mint, typically a
+// wrapper around GetInstallationToken.
+func NewTokenCache(mint func(ctx context.Context, installationID int64) (string, time.Time, error)) *TokenCache {
+	return &TokenCache{mint: mint, now: time.Now, margin: minTokenRefreshMargin, tokens: map[int64]cachedToken{}, gens: map[int64]uint64{}}
+}
+
+// Token returns a token for the installation that is valid for at least
+// the refresh margin.
+func (c *TokenCache) Token(ctx context.Context, installationID int64) (string, error) {
+	c.mu.Lock()
+	cached, ok := c.tokens[installationID]
+	gen := c.gens[installationID]
+	c.mu.Unlock()
+	if ok && c.now().Add(c.margin).Before(cached.expiresAt) {
+		return cached.token, nil
+	}
+
+	key := strconv.FormatInt(installationID, 10)
+	v, err, _ := c.flight.Do(key, func() (any, error) {
+		// The first caller's cancellation must not fail the others.
+		token, expiresAt, err := c.mint(context.WithoutCancel(ctx), installationID)
+		if err != nil {
+			return "", err
+		}
+		c.mu.Lock()
+		if c.gens[installationID] == gen {
+			c.tokens[installationID] = cachedToken{token: token, expiresAt: expiresAt}
+		}
+		c.mu.Unlock()
+		return token, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return v.(string), nil
+}
+
+// Evict forgets the installation's token, e.g. because the App was
+// uninstalled or the token was rejected.
+func (c *TokenCache) Evict(installationID int64) {
+	c.mu.Lock()
+	delete(c.tokens, installationID)
+	c.gens[installationID]++
+	c.mu.Unlock()
+	c.flight.Forget(strconv.FormatInt(installationID, 10))
+}
+
+var (
+	tokensOnce sync.Once
+	tokens     *TokenCache
+)
+
+// tokenCacheFor returns the process-wide installation token cache. The App
+// credentials and the review timeout are fixed for the life of the
+// process.
+func tokenCacheFor(cfg *config.Config) *TokenCache {
+	tokensOnce.Do(func() {
+		tokens = NewTokenCache(func(ctx context.Context, installationID int64) (string, time.Time, error) {
+			return GetInstallationToken(ctx, cfg, installationID)
+		})
+		tokens.margin = tokenRefreshMargin(cfg.AIReviewTimeout)
+	})
+	return tokens
+}
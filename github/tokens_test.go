@@ -0,0 +1,109 @@
+package github
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenCacheReuses(t *testing.T) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	var mints atomic.Int32
+	c := NewTokenCache(func(ctx context.Context, id int64) (string, time.Time, error) {
+		n := mints.Add(1)
+		return fmt.Sprintf("token-%d", n), now.Add(time.Hour), nil
+	})
+	c.now = func() time.Time { return now }
+
+	first, _ := c.Token(context.Background(), 1)
+	second, _ := c.Token(context.Background(), 1)
+	if first != second || mints.Load() != 1 {
+		t.Errorf("tokens %q, %q after %d mints; want one reused token", first, second, mints.Load())
+	}
+	// Within the refresh margin of expiry a new token is minted.
+	now = now.Add(time.Hour - c.margin + time.Second)
+	if third, _ := c.Token(context.Background(), 1); third == first {
+		t.Error("token close to expiry was reused")
+	}
+	c.Evict(1)
+	c.Token(context.Background(), 1)
+	if mints.Load() != 3 {
+		t.Errorf("%d mints, want 3 after eviction", mints.Load())
+	}
+}
+
+func TestTokenCacheSharesMint(t *testing.T) {
+	release := make(chan struct{})
+	var mints atomic.Int32
+	c := NewTokenCache(func(ctx context.Context, id int64) (string, time.Time, error) {
+		mints.Add(1)
+		<-release
+		return "token", time.Now().Add(time.Hour), nil
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Token(context.Background(), 7); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if mints.Load() != 1 {
+		t.Errorf("%d concurrent mints, want 1", mints.Load())
+	}
+}
+
+func TestTokenCacheEvictDuringMint(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	c := NewTokenCache(func(ctx context.Context, id int64) (string, time.Time, error) {
+		close(started)
+		<-release
+		return "stale", time.Now().Add(time.Hour), nil
+	})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Token(context.Background(), 3)
+	}()
+	<-started
+	c.Evict(3)
+	close(release)
+	<-done
+
+	c.mu.Lock()
+	_, cached := c.tokens[3]
+	c.mu.Unlock()
+	if cached {
+		t.Error("token minted before Evict was cached after it")
+	}
+}
+
+func TestTokenCacheErrors(t *testing.T) {
+	c := NewTokenCache(func(ctx context.Context, id int64) (string, time.Time, error) {
+		return "", time.Time{}, errors.New("bad credentials")
+	})
+	if _, err := c.Token(context.Background(), 1); err == nil {
+		t.Error("mint error was not returned")
+	}
+}
+
+func TestTokenRefreshMarginCoversReview(t *testing.T) {
+	for _, tt := range []struct{ timeout, want time.Duration }{
+		{0, 15 * time.Minute},
+		{10 * time.Minute, 15 * time.Minute},
+		{30 * time.Minute, 35 * time.Minute},
+	} {
+		if got := tokenRefreshMargin(tt.timeout); got != tt.want {
+			t.Errorf("tokenRefreshMargin(%v) = %v, want %v", tt.timeout, got, tt.want)
+		}
+	}
+}
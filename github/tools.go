@@ -0,0 +1,176 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"codesage/ai"
+)
+
+// maxToolLines caps how many lines get_file returns when no range is given.
+const maxToolLines = 400
+
+// ReviewTools returns the tools an agentic review may call to look at the
+// repository beyond the diff. Files are read at headSHA unless the model
+// asks for another ref, so it sees the code as the PR leaves it.
+func ReviewTools(client *Client, owner, repo string, prNumber int, headSHA string) []ai.Tool {
+	refOr := func(ref string) string {
+		if ref == "" {
+			return headSHA
+		}
+		return ref
+	}
+	return []ai.Tool{
+		{
+			Name:        "get_file",
+			Description: "Read a file of the repository, with line numbers. Use it to check definitions and callers of the changed code. Long files are cut off; ask for a line range to read further.",
+			Parameters: objectSchema(map[string]any{
+				"path":       stringParam("Path of the file from the repository root, e.g. internal/server/handler.go"),
+				"ref":        stringParam("Branch, tag or commit to read; defaults to the PR head"),
+				"start_line": intParam("First line to return, 1-based"),
+				"end_line":   intParam("Last line to return"),
+			}, "path"),
+			Call: func(ctx context.Context, raw json.RawMessage) (string, error) {
+				var args struct {
+					Path      string `json:"path"`
+					Ref       string `json:"ref"`
+					StartLine int    `json:"start_line"`
+					EndLine   int    `json:"end_line"`
+				}
+				if err := json.Unmarshal(raw, &args); err != nil || args.Path == "" {
+					return "", fmt.Errorf("expected {\"path\": ...}")
+				}
+				content, err := client.GetFileContent(ctx, owner, repo, args.Path, refOr(args.Ref))
+				if err != nil {
+					return "", err
+				}
+				return numberLines(content, args.StartLine, args.EndLine), nil
+			},
+		},
+		{
+			Name:        "list_dir",
+			Description: "List the files and directories in a directory of the repository.",
+			Parameters: objectSchema(map[string]any{
+				"path": stringParam("Directory from the repository root; empty for the root"),
+				"ref":  stringParam("Branch, tag or commit to list; defaults to the PR head"),
+			}),
+			Call: func(ctx context.Context, raw json.RawMessage) (string, error) {
+				var args struct {
+					Path string `json:"path"`
+					Ref  string `json:"ref"`
+				}
+				if err := json.Unmarshal(raw, &args); err != nil {
+					return "", fmt.Errorf("expected {\"path\": ...}")
+				}
+				entries, err := client.ListDir(ctx, owner, repo, args.Path, refOr(args.Ref))
+				if err != nil {
+					return "", err
+				}
+				var b strings.Builder
+				for _, e := range entries {
+					if e.Type == "dir" {
+						fmt.Fprintf(&b, "%s/\n", e.Path)
+					} else {
+						fmt.Fprintf(&b, "%s (%d bytes)\n", e.Path, e.Size)
+					}
+				}
+				if b.Len() == 0 {
+					return "(empty directory)", nil
+				}
+				return b.String(), nil
+			},
+		},
+		{
+			Name:        "search_in_repo",
+			Description: "Search the code of the repository, e.g. for the callers of a function. Searches the default branch, so code added by this PR is not found.",
+			Parameters: objectSchema(map[string]any{
+				"query": stringParam("Search terms, e.g. a function or type name"),
+			}, "query"),
+			Call: func(ctx context.Context, raw json.RawMessage) (string, error) {
+				var args struct {
+					Query string `json:"query"`
+				}
+				if err := json.Unmarshal(raw, &args); err != nil || strings.TrimSpace(args.Query) == "" {
+					return "", fmt.Errorf("expected {\"query\": ...}")
+				}
+				results, err := client.SearchCode(ctx, owner, repo, args.Query)
+				if err != nil {
+					return "", err
+				}
+				if len(results) == 0 {
+					return "no matches", nil
+				}
+				var b strings.Builder
+				for _, r := range results {
+					fmt.Fprintf(&b, "%s\n", r.Path)
+					for _, m := range r.TextMatches {
+						for _, line := range strings.Split(strings.TrimSpace(m.Fragment), "\n") {
+							fmt.Fprintf(&b, "    %s\n", line)
+						}
+					}
+				}
+				return b.String(), nil
+			},
+		},
+		{
+			Name:        "get_pr_commits",
+			Description: "List the commits of this pull request with their messages, oldest first.",
+			Call: func(ctx context.Context, _ json.RawMessage) (string, error) {
+				commits, err := client.GetPRCommits(ctx, owner, repo, prNumber)
+				if err != nil {
+					return "", err
+				}
+				var b strings.Builder
+				for _, c := range commits {
+					sha := c.SHA
+					if len(sha) > 7 {
+						sha = sha[:7]
+					}
+					fmt.Fprintf(&b, "%s %s (%s)\n%s\n\n", sha, c.Commit.Author.Name, c.Commit.Author.Date, strings.TrimSpace(c.Commit.Message))
+				}
+				return b.String(), nil
+			},
+		},
+	}
+}
+
+// numberLines returns lines start..end of content prefixed with their
+// numbers, or the first maxToolLines when no range is given.
+func numberLines(content string, start, end int) string {
+	lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	if start < 1 {
+		start = 1
+	}
+	if end < start {
+		end = start + maxToolLines - 1
+	}
+	var b strings.Builder
+	for i := start; i <= end && i <= len(lines); i++ {
+		fmt.Fprintf(&b, "%5d  %s\n", i, lines[i-1])
+	}
+	if end < len(lines) {
+		fmt.Fprintf(&b, "… %d more lines; request start_line %d to continue\n", len(lines)-end, end+1)
+	}
+	if b.Len() == 0 {
+		return fmt.Sprintf("the file has only %d lines", len(lines))
+	}
+	return b.String()
+}
+
+func objectSchema(properties map[string]any, required ...string) map[string]any {
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func stringParam(description string) map[string]any {
+	return map[string]any{"type": "string", "description": description}
+}
+
+func intParam(description string) map[string]any {
+	return map[string]any{"type": "integer", "description": description}
+}
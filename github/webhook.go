@@ -2,7 +2,9 @@
 package github
 
 import (
+    "context"
     "encoding/json"
+    "errors"
     "fmt"
     "strings"
     "io"
@@ -10,6 +12,8 @@ import (
     "github.com/gin-gonic/gin"
     "codesage/config"
     "codesage/ai"
+    "codesage/checks"
+    "codesage/enrich"
 )
 
 func HandleWebhook(c *gin.Context, cfg *config.Config) {
@@ -21,6 +25,9 @@ func HandleWebhook(c *gin.Context, cfg *config.Config) {
     case "pull_request":
         handlePullRequest(c, cfg)
         return
+    case "installation":
+        handleInstallation(c, cfg)
+        return
     case "ping":
         fmt.Println("🏓 Ping event received - webhook setup successful!")
         c.JSON(200, gin.H{"status": "pong"})
@@ -37,19 +44,21 @@ func HandleWebhook(c *gin.Context, cfg *config.Config) {
     }
 }
 
-func handlePullRequest(c *gin.Context, cfg *config.Config) {
+// readPayload reads and verifies a webhook delivery and parses its JSON
+// payload. On failure it has already responded.
+func readPayload(c *gin.Context, cfg *config.Config) (map[string]interface{}, bool) {
     // Read the raw body first
     body, err := io.ReadAll(c.Request.Body)
     if err != nil {
         fmt.Printf("❌ Failed to read request body: %v\n", err)
         c.JSON(400, gin.H{"error": "Failed to read body"})
-        return
+        return nil, false
     }
     // Verify webhook signature for security
     if !VerifyWebhookSignature(cfg.GitHubWebhookSecret, body, c.GetHeader("X-Hub-Signature-256")) {
         fmt.Println("❌ Invalid webhook signature")
         c.JSON(401, gin.H{"error": "invalid signature"})
-        return
+        return nil, false
     }
     
     bodyStr := string(body)
@@ -63,7 +72,7 @@ func handlePullRequest(c *gin.Context, cfg *config.Config) {
         if err != nil {
             fmt.Printf("❌ Failed to URL decode: %v\n", err)
             c.JSON(400, gin.H{"error": "Failed to decode payload"})
-            return
+            return nil, false
         }
         payloadStr = decoded
     } else {
@@ -84,6 +93,31 @@ func handlePullRequest(c *gin.Context, cfg *config.Config) {
         fmt.Printf("❌ Failed to parse JSON: %v\n", err)
         fmt.Printf("🔍 Trying to parse: %s\n", payloadStr[:min(500, len(payloadStr))])
         c.JSON(400, gin.H{"error": "Invalid JSON payload"})
+        return nil, false
+    }
+    return payload, true
+}
+
+// handleInstallation forgets the cached token of an installation that was
+// removed or suspended, so it is not used again if it is reinstated.
+func handleInstallation(c *gin.Context, cfg *config.Config) {
+    payload, ok := readPayload(c, cfg)
+    if !ok {
+        return
+    }
+    action, _ := payload["action"].(string)
+    instObj, _ := payload["installation"].(map[string]interface{})
+    idf, _ := instObj["id"].(float64)
+    if installationID := int64(idf); installationID != 0 && (action == "deleted" || action == "suspend") {
+        tokenCacheFor(cfg).Evict(installationID)
+        fmt.Printf("🔑 Installation %d %s; dropped its cached token\n", installationID, action)
+    }
+    c.JSON(200, gin.H{"status": "received", "event": "installation"})
+}
+
+func handlePullRequest(c *gin.Context, cfg *config.Config) {
+    payload, ok := readPayload(c, cfg)
+    if !ok {
         return
     }
     
@@ -109,8 +143,15 @@ func handlePullRequest(c *gin.Context, cfg *config.Config) {
     
     fmt.Printf("🎯 PR Action: %s\n", action)
     
-    // Only analyze when PR is opened or synchronized (new commits)
-    if action != "opened" && action != "synchronize" {
+    // Only analyze when PR is opened or synchronized (new commits), or when
+    // the cache bypass label is added to ask for a fresh review
+    bypassAdded := false
+    if action == "labeled" {
+        labelData, _ := payload["label"].(map[string]interface{})
+        name, _ := labelData["name"].(string)
+        bypassAdded = name != "" && name == cfg.AICacheBypassLabel
+    }
+    if action != "opened" && action != "synchronize" && !bypassAdded {
         fmt.Printf("⏭️ Skipping action: %s\n", action)
         c.JSON(200, gin.H{"status": "received", "message": "Action ignored"})
         return
@@ -143,6 +184,19 @@ func handlePullRequest(c *gin.Context, cfg *config.Config) {
     userData, _ := prData["user"].(map[string]interface{})
     user, _ := userData["login"].(string)
     
+    headData, _ := prData["head"].(map[string]interface{})
+    headSHA, _ := headData["sha"].(string)
+    
+    // A bypass label forces a new review even if the diff was reviewed before
+    fresh := bypassAdded
+    labels, _ := prData["labels"].([]interface{})
+    for _, l := range labels {
+        label, _ := l.(map[string]interface{})
+        if name, _ := label["name"].(string); name != "" && name == cfg.AICacheBypassLabel {
+            fresh = true
+        }
+    }
+    
     if title == "" || owner == "" || repo == "" || prNumber == 0 {
         fmt.Printf("❌ Missing required PR data: title=%s, owner=%s, repo=%s, number=%d\n", 
                    title, owner, repo, prNumber)
@@ -161,23 +215,22 @@ func handlePullRequest(c *gin.Context, cfg *config.Config) {
         }
     }
 
-    // If installed, switch cfg token to installation token for API calls
-    var restoreToken string
-    if installationID != 0 {
-        token, _, err := GetInstallationToken(cfg, installationID)
-        if err != nil {
-            fmt.Printf("❌ Failed to get installation token: %v\n", err)
-            c.JSON(500, gin.H{"error": "Failed to get installation token"})
-            return
-        }
-        restoreToken = cfg.GitHubToken
-        cfg.GitHubToken = token
-        defer func() { cfg.GitHubToken = restoreToken }()
+    // GitHub drops the delivery after a few seconds; finish the job anyway
+    apiCtx := context.WithoutCancel(c.Request.Context())
+    
+    // Act as the installation that sent the event, if any. The credentials
+    // live in this delivery's client only; cfg is shared by concurrent
+    // deliveries and never modified.
+    client, err := installationClient(apiCtx, cfg, installationID)
+    if err != nil {
+        fmt.Printf("❌ Failed to get installation token: %v\n", err)
+        c.JSON(500, gin.H{"error": "Failed to get installation token"})
+        return
     }
-
+    
     // Step 1: Get the file changes from GitHub
     fmt.Println("🔄 Fetching PR file changes from GitHub API...")
-    files, err := GetPRFiles(owner, repo, prNumber, cfg)
+    files, err := client.GetPRFiles(apiCtx, owner, repo, prNumber)
     if err != nil {
         fmt.Printf("❌ Failed to get PR files: %v\n", err)
         c.JSON(500, gin.H{"error": "Failed to fetch PR files"})
@@ -190,20 +243,18 @@ func handlePullRequest(c *gin.Context, cfg *config.Config) {
         return
     }
     
-    // Step 2: Combine all file changes into one diff
-    var fullDiff strings.Builder
+    // Step 2: Collect the per-file patches; large PRs are chunked by the AI layer
+    var diffFiles []ai.FileDiff
     totalLines := 0
     for _, file := range files {
         if file.Patch != "" {
-            fullDiff.WriteString(fmt.Sprintf("\n--- %s ---\n", file.Filename))
-            fullDiff.WriteString(file.Patch)
-            fullDiff.WriteString("\n")
+            diffFiles = append(diffFiles, ai.FileDiff{Path: file.Filename, Patch: file.Patch})
             // Count approximate lines for debugging
             totalLines += strings.Count(file.Patch, "\n")
         }
     }
     
-    if fullDiff.Len() == 0 {
+    if len(diffFiles) == 0 {
         fmt.Println("⚠️ No code changes to analyze")
         c.JSON(200, gin.H{"status": "received", "message": "No code changes"})
         return
@@ -211,28 +262,193 @@ func handlePullRequest(c *gin.Context, cfg *config.Config) {
     
     fmt.Printf("📊 Analyzing %d lines of code changes\n", totalLines)
     
-    // Step 3: Send to AI for analysis
-    fmt.Println("🤖 Sending to AI for analysis...")
-    analysis, err := ai.AnalyzeWithGemini(fullDiff.String(), title)
+    // Step 3: Run the static checks; they need no AI provider, so the PR
+    // gets feedback even when every provider is down or out of quota
+    var static []ai.Finding
+    if cfg.StaticChecks != "off" {
+        static = checks.Run(diffFiles, cfg.StaticChecksDisabled)
+        fmt.Printf("🔎 Static checks found %d issue(s) %s\n", len(static), checks.Summary(static))
+    }
+    if cfg.StaticChecks == "only" {
+        if err := client.PostComment(apiCtx, owner, repo, prNumber, staticComment("", static)); err != nil {
+            fmt.Printf("❌ Failed to post comment: %v\n", err)
+            c.JSON(500, gin.H{"error": "Failed to post comment"})
+            return
+        }
+        c.JSON(200, gin.H{"status": "success", "message": "Static check results posted"})
+        return
+    }
+    
+    // Step 4: Send to AI for analysis, using this repo's settings and the
+    // large-PR models when the change is big
+    prCfg := cfg.ForPR(owner+"/"+repo, totalLines)
+    reviewer, err := ai.FromConfig(prCfg)
+    if err != nil {
+        fmt.Printf("❌ Failed to set up AI provider: %v\n", err)
+        if len(static) > 0 {
+            note := "⚠️ The AI review is unavailable right now; showing the static checks only."
+            if err := client.PostComment(apiCtx, owner, repo, prNumber, staticComment(note, static)); err != nil {
+                fmt.Printf("❌ Failed to post comment: %v\n", err)
+            }
+        }
+        c.JSON(500, gin.H{"error": "AI provider not configured"})
+        return
+    }
+    fmt.Printf("🤖 Sending to %s for analysis...\n", reviewer.Name())
+    // Bound the review by our own deadline
+    ctx, cancel := context.WithTimeout(apiCtx, cfg.AIReviewTimeout)
+    defer cancel()
+    ctx = ai.WithAttribution(ctx, ai.Attribution{Repo: owner + "/" + repo, Installation: installationID, PR: prNumber})
+    
+    // Add the enclosing function or type of each hunk, read at the PR
+    // head, so the model does not have to guess what a change is part of
+    if prCfg.AIContextBudget > 0 && headSHA != "" {
+        diffFiles = enrich.Enrich(ctx, diffFiles, func(ctx context.Context, path string) (string, error) {
+            return client.GetFileContent(ctx, owner, repo, path, headSHA)
+        }, prCfg.AIContextBudget)
+    }
+    
+    // Stream into a placeholder comment so the PR shows progress on long reviews
+    var stream *commentStream
+    if cfg.AIStreaming {
+        stream, err = startCommentStream(ctx, client, owner, repo, prNumber, cfg.AIStreamInterval)
+        if err != nil {
+            fmt.Printf("⚠️ Could not post progress comment, reviewing without it: %v\n", err)
+        }
+    }
+    // publish posts the final comment, replacing the placeholder if there is one
+    publish := func(body string) error {
+        if stream != nil {
+            return stream.Finish(body)
+        }
+        return client.PostComment(context.WithoutCancel(ctx), owner, repo, prNumber, body)
+    }
+    
+    reviewReq := ai.ReviewRequest{
+        Title: title,
+        Diff:  ai.RenderDiff(diffFiles),
+        Files: diffFiles,
+        Fresh: fresh,
+    }
+    if stream != nil {
+        reviewReq.OnProgress = stream.Update
+    }
+    // In agent mode the model may read files and search the repository
+    // before answering
+    if prCfg.AIReviewMode == "agent" && headSHA != "" {
+        reviewReq.Tools = ReviewTools(client, owner, repo, prNumber, headSHA)
+        reviewReq.MaxToolSteps = prCfg.AIAgentMaxSteps
+    }
+    review, err := reviewer.Review(ctx, reviewReq)
+    var blocked *ai.BlockedError
+    if errors.As(err, &blocked) {
+        // Tell the author why there is no review rather than staying silent
+        fmt.Printf("⚠️ AI review blocked: %v\n", err)
+        if err := publish(staticComment(blockedNote(blocked), static)); err != nil {
+            fmt.Printf("❌ Failed to post comment: %v\n", err)
+            c.JSON(500, gin.H{"error": "Failed to post comment"})
+            return
+        }
+        c.JSON(200, gin.H{"status": "blocked", "message": err.Error()})
+        return
+    }
+    var empty *ai.EmptyResponseError
+    if errors.As(err, &empty) {
+        fmt.Printf("⚠️ AI review came back empty: %v\n", err)
+        if err := publish(staticComment(emptyNote(empty, cfg.AICacheBypassLabel), static)); err != nil {
+            fmt.Printf("❌ Failed to post comment: %v\n", err)
+            c.JSON(500, gin.H{"error": "Failed to post comment"})
+            return
+        }
+        c.JSON(200, gin.H{"status": "empty", "message": err.Error()})
+        return
+    }
     if err != nil {
         fmt.Printf("❌ AI analysis failed: %v\n", err)
+        // Post what the static checks found, and never leave the
+        // placeholder claiming a review is in progress
+        if stream != nil || len(static) > 0 {
+            note := "❌ The AI review could not be completed. Push a new commit to try again."
+            if err := publish(staticComment(note, static)); err != nil {
+                fmt.Printf("❌ Failed to post comment: %v\n", err)
+            }
+        }
         c.JSON(500, gin.H{"error": "AI analysis failed"})
         return
     }
+    stop := ""
+    if review.Truncated {
+        stop = stopNote(review.StopReason)
+    }
+    extra := checks.Merge(static, review.Findings)
+    analysis := review.Text
+    if review.Coverage != "" {
+        analysis += "\n\n" + review.Coverage
+    }
+    if stop != "" {
+        analysis += "\n\n" + stop
+    }
+    if len(extra) > 0 {
+        analysis += "\n" + ai.RenderFindings("Static checks", extra)
+    }
+    providerNote := fmt.Sprintf("Reviewed with `%s`", review.Provider)
+    if review.Model != "" {
+        providerNote = fmt.Sprintf("Reviewed with `%s` (`%s`)", review.Provider, review.Model)
+    }
+    if len(review.FallbackFrom) > 0 {
+        providerNote += fmt.Sprintf(" (fallback after `%s` was unavailable)", strings.Join(review.FallbackFrom, "`, `"))
+    }
+    if review.Redactions > 0 {
+        providerNote += fmt.Sprintf(" · %d sensitive value(s) redacted before review", review.Redactions)
+    }
+    if review.PromptVersion != "" {
+        providerNote += fmt.Sprintf(" · prompts `%s`", review.PromptVersion)
+    }
+    if review.Cached {
+        providerNote += fmt.Sprintf(" · reused from an earlier review of identical changes; add the `%s` label to re-run", cfg.AICacheBypassLabel)
+    }
     
-    fmt.Printf("✅ AI analysis completed (%d characters)\n", len(analysis))
+    fmt.Printf("✅ AI analysis completed (%d findings, %d prompt + %d completion tokens, ~$%.4f)\n",
+               len(review.Findings), review.Usage.PromptTokens, review.Usage.CompletionTokens, review.Usage.CostUSD)
     
-    // Step 4: Format the comment nicely
-    comment := fmt.Sprintf(`## 🤖 CodeSage AI Review
-
-%s
-
----
-*This review was automatically generated by CodeSage. Please review the suggestions and apply them as appropriate.*`, analysis)
+    footer := fmt.Sprintf(`---
+*This review was automatically generated by CodeSage. Please review the suggestions and apply them as appropriate.*
+<sub>%s</sub>`, providerNote)
     
-    // Step 5: Post the comment to GitHub
+    // Step 5: Post the findings as review comments on the changed lines.
+    // Only a parsed report has findings with lines; free-form reviews and
+    // rejected reviews fall back to one comment.
+    parsed := review.Summary != "" || len(review.Findings) > 0
+    if cfg.ReviewComments == "inline" && headSHA != "" && parsed {
+        summary := review.Summary
+        if review.Coverage != "" {
+            summary = strings.TrimSpace(summary + "\n\n" + review.Coverage)
+        }
+        if stop != "" {
+            summary = strings.TrimSpace(summary + "\n\n" + stop)
+        }
+        pr := inlineReview(diffFiles, headSHA, summary, review.Findings, extra, footer)
+        fmt.Printf("💬 Posting review with %d inline comment(s) to GitHub...\n", len(pr.Comments))
+        posted, err := client.CreateReview(context.WithoutCancel(ctx), owner, repo, prNumber, pr)
+        if err == nil {
+            if stream != nil {
+                if err := stream.Discard(); err != nil {
+                    fmt.Printf("⚠️ Could not delete progress comment: %v\n", err)
+                }
+            }
+            fmt.Printf("✅ Successfully posted AI review %s\n", posted.HTMLURL)
+            c.JSON(200, gin.H{"status": "success", "message": "AI review posted", "inline_comments": len(pr.Comments)})
+            return
+        }
+        // GitHub rejects the whole review if a line is not in the diff,
+        // e.g. after a push moved the head
+        fmt.Printf("⚠️ Could not post inline review, posting a comment instead: %v\n", err)
+    }
+    
+    // Step 6: Post the whole review as one comment
+    comment := fmt.Sprintf("%s\n\n%s\n\n%s", reviewHeading, analysis, footer)
     fmt.Println("💬 Posting comment to GitHub...")
-    if err := PostComment(owner, repo, prNumber, comment, cfg); err != nil {
+    if err := publish(comment); err != nil {
         fmt.Printf("❌ Failed to post comment: %v\n", err)
         c.JSON(500, gin.H{"error": "Failed to post comment"})
         return
@@ -242,6 +458,61 @@ func handlePullRequest(c *gin.Context, cfg *config.Config) {
     c.JSON(200, gin.H{"status": "success", "message": "AI review posted"})
 }
 
+// staticComment builds a comment from the static checks alone; note says
+// why there is no AI review, if one was expected.
+func staticComment(note string, static []ai.Finding) string {
+    var b strings.Builder
+    b.WriteString(reviewHeading + "\n\n")
+    if note != "" {
+        b.WriteString(note + "\n")
+    }
+    if len(static) == 0 {
+        b.WriteString("\nThe static checks found no issues. 🎉\n")
+    } else {
+        b.WriteString(ai.RenderFindings("Static checks", static))
+    }
+    b.WriteString("\n---\n<sub>Results of the CodeSage rule-based static checks.</sub>")
+    return b.String()
+}
+
+// blockedNote explains a review the provider refused to produce.
+func blockedNote(e *ai.BlockedError) string {
+    what := "the review request"
+    if e.Stage == "response" {
+        what = "the generated review"
+    }
+    note := fmt.Sprintf("⚠️ %s's safety filters blocked %s (`%s`)", e.Provider, what, e.Reason)
+    if e.Reason == "RECITATION" {
+        note = fmt.Sprintf("⚠️ %s blocked %s because it closely matched existing published code", e.Provider, what)
+    }
+    if len(e.Categories) > 0 {
+        note += fmt.Sprintf(", flagged as %s", strings.Join(e.Categories, ", "))
+    }
+    return note + ". No review was posted for this change; reviewing it manually is recommended."
+}
+
+// emptyNote explains a provider that finished without writing a review.
+func emptyNote(e *ai.EmptyResponseError, retryLabel string) string {
+    if e.StopReason == ai.StopMaxTokens {
+        return fmt.Sprintf("⚠️ %s reached its output token limit (`%s`) before writing any review. Thinking models can spend the whole limit on reasoning; raise `<PREFIX>_MAX_TOKENS` for this provider, then push a new commit or add the `%s` label.",
+                           e.Provider, e.FinishReason, retryLabel)
+    }
+    return fmt.Sprintf("⚠️ %s stopped without writing a review (finish reason `%s`). This is usually temporary; push a new commit or add the `%s` label to try again.",
+                       e.Provider, e.FinishReason, retryLabel)
+}
+
+// stopNote explains why a review is incomplete.
+func stopNote(reason ai.StopReason) string {
+    switch reason {
+    case ai.StopSafety:
+        return "_(The review was cut short by the model's safety filters.)_"
+    case ai.StopRecitation:
+        return "_(The review was cut short because the model's output closely matched existing published code.)_"
+    default:
+        return "_(The review was cut short because the model reached its output limit.)_"
+    }
+}
+
 // Helper function for min (Go doesn't have built-in min for int)
 func min(a, b int) int {
     if a < b {
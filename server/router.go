@@ -3,6 +3,7 @@ package server
 import (
 	"codesage/config"
 	"codesage/github"
+	"expvar"
 	"github.com/gin-gonic/gin"
 )
 
@@ -20,6 +21,13 @@ func SetupRouter(cfg *config.Config) *gin.Engine {
 		c.JSON(200, gin.H{"message": "handle GitHub OAuth callback here"})
 	})
 
+	r.GET("/api/usage", func(c *gin.Context) {
+		handleUsage(c, cfg)
+	})
+
+	// Provider request, retry and rate-limit counters live under "ai"
+	r.GET("/debug/vars", gin.WrapH(expvar.Handler()))
+
 	r.GET("/", func(c *gin.Context) {
 		c.JSON(200, gin.H{"message": "CodeSage is running"})
 	})
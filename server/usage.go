@@ -0,0 +1,75 @@
+package server
+
+import (
+	"crypto/subtle"
+	"strconv"
+	"time"
+
+	"codesage/ai"
+	"codesage/config"
+	"codesage/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// handleUsage reports AI token usage and estimated cost. Query parameters
+// repo, installation, pr, since and until (RFC 3339 or YYYY-MM-DD) narrow
+// the records. USAGE_API_TOKEN must be sent as a bearer token; without one
+// configured the endpoint is disabled, since the records name private
+// repositories and PRs.
+func handleUsage(c *gin.Context, cfg *config.Config) {
+	if cfg.UsageAPIToken == "" {
+		c.JSON(403, gin.H{"error": "usage API is disabled; set USAGE_API_TOKEN to enable it"})
+		return
+	}
+	given := []byte(c.GetHeader("Authorization"))
+	want := []byte("Bearer " + cfg.UsageAPIToken)
+	if subtle.ConstantTimeCompare(given, want) != 1 {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	filter := ai.UsageFilter{Repo: c.Query("repo")}
+	if v := c.Query("installation"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "invalid installation"})
+			return
+		}
+		filter.Installation = id
+	}
+	if v := c.Query("pr"); v != "" {
+		pr, err := strconv.Atoi(v)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "invalid pr"})
+			return
+		}
+		filter.PR = pr
+	}
+	var err error
+	if filter.Since, err = parseTimeParam(c.Query("since")); err != nil {
+		c.JSON(400, gin.H{"error": "invalid since"})
+		return
+	}
+	if filter.Until, err = parseTimeParam(c.Query("until")); err != nil {
+		c.JSON(400, gin.H{"error": "invalid until"})
+		return
+	}
+
+	report, err := ai.UsageLedger(cfg).Report(filter)
+	if err != nil {
+		utils.Errorf("failed to read usage ledger: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to read usage ledger"})
+		return
+	}
+	c.JSON(200, report)
+}
+
+func parseTimeParam(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", v)
+}